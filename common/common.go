@@ -4,7 +4,6 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
-	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/validation"
 	"github.com/google/uuid"
 	"github.com/sendgrid/rest"
@@ -144,7 +143,7 @@ func TransformString(in string, uppercase bool) string {
 	t := transform.Chain(norm.NFD, transform.RemoveFunc(isMn), norm.NFC)
 	result, _, err := transform.String(t, in)
 	if err != nil {
-		logs.Error("Failed to transform %s ", in)
+		getLogger().Error("common: transform string failed", "input", in, "error", err)
 		return ""
 	}
 
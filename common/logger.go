@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// Logger receive logs of the package, args are key/value pairs like
+// log/slog so *slog.Logger can be used directly, a Logger can also be given
+// to notification.SetLogger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+var (
+	loggerMutex sync.RWMutex
+	// beego logs until SetLogger is called
+	packageLogger Logger = beegoLogger{}
+)
+
+// SetLogger replace logger of the package, nil discard logs
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	packageLogger = logger
+}
+
+func getLogger() Logger {
+	loggerMutex.RLock()
+	defer loggerMutex.RUnlock()
+	return packageLogger
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// beegoLogger write to beego logs as "msg key=value ..."
+type beegoLogger struct{}
+
+func (beegoLogger) Debug(msg string, args ...interface{}) {
+	logs.Debug("%s", formatLog(msg, args))
+}
+
+func (beegoLogger) Info(msg string, args ...interface{}) {
+	logs.Info("%s", formatLog(msg, args))
+}
+
+func (beegoLogger) Warn(msg string, args ...interface{}) {
+	logs.Warn("%s", formatLog(msg, args))
+}
+
+func (beegoLogger) Error(msg string, args ...interface{}) {
+	logs.Error("%s", formatLog(msg, args))
+}
+
+func formatLog(msg string, args []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&sb, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&sb, " %v=%q", args[i], fmt.Sprint(args[i+1]))
+	}
+	return sb.String()
+}
@@ -0,0 +1,300 @@
+package notification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/certificate"
+	"github.com/sideshow/apns2/token"
+)
+
+//=================================================================
+// iOS service
+// - Support p12, pem, p8 key
+//=================================================================
+type APNsConfig struct {
+	ID      string `json:"id"`
+	Tenant  string `json:"tenant"`
+	KeyType string `json:"key_type"`
+	// key is loaded from only one of: file path, raw bytes, base64 string,
+	// key data
+	KeyFilePath string `json:"key_path"`
+	KeyBytes    []byte `json:"-"`
+	// standard base64 of key file, ex: from env or vault
+	KeyBase64 string `json:"key_base64"`
+	// key type of KeyBase64, used when KeyType is empty
+	KeyBase64Type string `json:"key_base64_type"`
+	// raw content of key file (.pem or .p8)
+	KeyData string `json:"key_data"`
+	// password for .p12 and .pem
+	Password string `json:"password"`
+	// ID for .p8
+	KeyID  string `json:"p8_key_id"`
+	TeamID string `json:"p8_team_id"`
+	// type
+	IsProduction bool   `json:"is_production"`
+	AppBundleID  string `json:"app_bundle_id"`
+	// number of concurrent pushes over the HTTP/2 connection, default 10
+	Concurrency int `json:"concurrency"`
+}
+
+const defaultAPNsConcurrency = 10
+
+var (
+	ErrUnsupportedKeyType  = errors.New("platform APNs: key type was invalid, APNs only support for .p12, .pem, .p8")
+	ErrMissingLiveActivity = errors.New("platform APNs: liveactivity push need APNs.LiveActivity")
+	ErrMultipleAPNsKeys    = errors.New("platform APNs: only one of key_path, key bytes, key_base64, key_data can be set")
+)
+
+// APNsInitFromConfig create apns2 client, it return nil when key is invalid.
+// Deprecated: use APNsClientFromConfig to get the error.
+func APNsInitFromConfig(config *APNsConfig) *apns2.Client {
+	client, err := APNsClientFromConfig(config)
+	if err != nil {
+		getLogger().Error("platform APNs: init APNs failed", "app", config.ID, "error", err)
+		return nil
+	}
+	return client
+}
+
+// APNsClientFromConfig create apns2 client from .p12, .pem or .p8 key
+func APNsClientFromConfig(config *APNsConfig) (*apns2.Client, error) {
+	if config.keySources() > 1 {
+		return nil, ErrMultipleAPNsKeys
+	}
+	switch config.keyType() {
+	case "p12", "pem":
+		certificateKey, err := apnsCertificate(config)
+		if err != nil {
+			return nil, fmt.Errorf("platform APNs: create certificate, please check key file is correct or not: %w", err)
+		}
+		return apns_NewClient_P12_Pem(certificateKey, config), nil
+	case "p8":
+		authKey, err := apnsAuthKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("platform APNs: create authentication key, please check key file is correct or not: %w", err)
+		}
+		return apns_NewClient_P8(authKey, config), nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+// keyType return KeyType, fall back to KeyBase64Type
+func (c *APNsConfig) keyType() string {
+	if c.KeyType == "" {
+		return c.KeyBase64Type
+	}
+	return c.KeyType
+}
+
+// hasKey return true when any key source is set
+func (c *APNsConfig) hasKey() bool {
+	return c.keySources() > 0
+}
+
+// keySources return number of key sources which are set
+func (c *APNsConfig) keySources() int {
+	sources := 0
+	for _, set := range []bool{c.KeyFilePath != "", len(c.KeyBytes) > 0, c.KeyBase64 != "", c.KeyData != ""} {
+		if set {
+			sources++
+		}
+	}
+	return sources
+}
+
+// keyBytes return in-memory key from KeyBytes, KeyBase64 or KeyData
+func (c *APNsConfig) keyBytes() ([]byte, error) {
+	switch {
+	case len(c.KeyBytes) > 0:
+		return c.KeyBytes, nil
+	case c.KeyBase64 != "":
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(c.KeyBase64))
+		if err != nil {
+			return nil, fmt.Errorf("decode key_base64: %w", err)
+		}
+		return data, nil
+	case c.KeyData != "":
+		return []byte(c.KeyData), nil
+	}
+	return nil, errors.New("key was empty")
+}
+
+// apnsCertificate load .p12 or .pem certificate
+func apnsCertificate(config *APNsConfig) (tls.Certificate, error) {
+	if config.KeyFilePath != "" {
+		if config.keyType() == "p12" {
+			return certificate.FromP12File(config.KeyFilePath, config.Password)
+		}
+		return certificate.FromPemFile(config.KeyFilePath, config.Password)
+	}
+	data, err := config.keyBytes()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if config.keyType() == "p12" {
+		return certificate.FromP12Bytes(data, config.Password)
+	}
+	return certificate.FromPemBytes(data, config.Password)
+}
+
+// apnsAuthKey load .p8 authentication key
+func apnsAuthKey(config *APNsConfig) (*ecdsa.PrivateKey, error) {
+	if config.KeyFilePath != "" {
+		return token.AuthKeyFromFile(config.KeyFilePath)
+	}
+	data, err := config.keyBytes()
+	if err != nil {
+		return nil, err
+	}
+	return token.AuthKeyFromBytes(data)
+}
+
+func apns_NewClient_P12_Pem(cer tls.Certificate, config *APNsConfig) *apns2.Client {
+	if config.IsProduction {
+		return apns2.NewClient(cer).Production()
+	} else {
+		return apns2.NewClient(cer).Development()
+	}
+}
+
+func apns_NewClient_P8(authKey *ecdsa.PrivateKey, config *APNsConfig) *apns2.Client {
+	// init token
+	token := &token.Token{
+		AuthKey: authKey,
+		// KeyID from developer account (Certificates, Identifiers & Profiles -> Keys)
+		KeyID: config.KeyID,
+		// TeamID from developer account (View Account -> Membership)
+		TeamID: config.TeamID,
+	}
+	// create client
+	if config.IsProduction {
+		return apns2.NewTokenClient(token).Production()
+	} else {
+		return apns2.NewTokenClient(token).Development()
+	}
+}
+
+// APNs reasons which mean the device token will never work again.
+// BadDeviceToken is also returned for a token of the other environment or an
+// FCM token of a fan-out, such token is kept when another client delivered
+// it. DeviceTokenNotForTopic is a token of another app so it is a failure.
+var apnsInvalidTokenReasons = map[string]bool{
+	apns2.ReasonBadDeviceToken: true,
+	apns2.ReasonUnregistered:   true,
+	apns2.ReasonExpiredToken:   true,
+}
+
+type apnsSender struct {
+	client      *apns2.Client
+	appBundleID string
+	concurrency int
+}
+
+// NewAPNsSender wrap an apns2 client as Sender, config.AppBundleID is used as
+// apns-topic and config.Concurrency bound the number of in-flight pushes
+func NewAPNsSender(client *apns2.Client, config *APNsConfig) Sender {
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultAPNsConcurrency
+	}
+	return &apnsSender{
+		client:      client,
+		appBundleID: config.AppBundleID,
+		concurrency: concurrency,
+	}
+}
+
+func (s *apnsSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	// create payload data
+	pData := apnsAps(msg)
+	msgData, err := encodePayloadData(PLATFORM_APNs, msg.PayloadData)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range msgData {
+		pData.Custom(k, v)
+	}
+	// encode once, every worker share the same bytes
+	rawPayload, err := json.Marshal(pData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPayloadSize(PLATFORM_APNs, rawPayload, APNsMaxPayloadSize); err != nil {
+		return nil, err
+	}
+	opts := msg.apnsOptions()
+	pushType := apns2.EPushType(apnsPushType(msg))
+	if string(pushType) == APNsPushTypeLiveActivity && opts.LiveActivity == nil {
+		return nil, ErrMissingLiveActivity
+	}
+	topic := apnsTopic(s.appBundleID, string(pushType))
+	priority := apnsPriority(msg)
+
+	results := sendEach(msg.Tokens, s.concurrency, func(token string) TokenResult {
+		notification := &apns2.Notification{
+			DeviceToken: token,
+			Payload:     rawPayload,
+			Topic:       topic,
+			PushType:    pushType,
+			Priority:    priority,
+			Expiration:  opts.Expiration,
+			CollapseID:  opts.CollapseID,
+		}
+		res, err := s.client.PushWithContext(ctx, notification)
+		return apnsResult(token, res, err)
+	})
+	return &DeliveryReport{Results: results}, nil
+}
+
+// apnsTopic return apns-topic of push type, Live Activity and VoIP pushes
+// use their own topic of the app
+func apnsTopic(appBundleID, pushType string) string {
+	suffix := ""
+	switch pushType {
+	case APNsPushTypeLiveActivity:
+		suffix = ".push-type.liveactivity"
+	case APNsPushTypeVoIP:
+		suffix = ".voip"
+	}
+	if strings.HasSuffix(appBundleID, suffix) {
+		return appBundleID
+	}
+	return appBundleID + suffix
+}
+
+func apnsResult(deviceToken string, res *apns2.Response, err error) TokenResult {
+	result := TokenResult{
+		Token:    deviceToken,
+		Platform: PLATFORM_APNs,
+	}
+	if err != nil {
+		// connection error, request never reached APNs
+		result.Status = StatusFailed
+		result.Retryable = true
+		result.Err = err
+		return result
+	}
+	result.StatusCode = res.StatusCode
+	result.Reason = res.Reason
+	result.MessageID = res.ApnsID
+	switch {
+	case res.Sent():
+		result.Status = StatusSent
+	case apnsInvalidTokenReasons[res.Reason]:
+		result.Status = StatusInvalidToken
+	default:
+		result.Status = StatusFailed
+		result.Retryable = res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+	}
+	return result
+}
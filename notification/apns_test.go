@@ -0,0 +1,169 @@
+package notification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	Sync "sync"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+)
+
+func TestAPNsSender_PushTypes(t *testing.T) {
+	type request struct {
+		header  http.Header
+		payload map[string]interface{}
+	}
+	var (
+		mutex    Sync.Mutex
+		requests []request
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := request{header: r.Header}
+		_ = json.Unmarshal(body, &req.payload)
+		mutex.Lock()
+		requests = append(requests, req)
+		mutex.Unlock()
+		w.Header().Set("apns-id", "id")
+	}))
+	defer server.Close()
+	sender := NewAPNsSender(&apns2.Client{Host: server.URL, HTTPClient: server.Client()}, &APNsConfig{AppBundleID: "com.example.app"})
+	ctx := context.Background()
+
+	stale := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, err := sender.Send(ctx, &Message{
+		Title:  "Driver is near",
+		Tokens: []string{"activity-token"},
+		APNs: &APNsOptions{LiveActivity: &LiveActivityOptions{
+			ContentState: map[string]interface{}{"eta_minutes": 3},
+			Timestamp:    stale.Add(-time.Minute),
+			StaleDate:    stale,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Send() live activity error = %v", err)
+	}
+	_, err = sender.Send(ctx, &Message{
+		PayloadData: map[string]string{"call_id": "42"},
+		Tokens:      []string{"voip-token"},
+		APNs:        &APNsOptions{PushType: APNsPushTypeVoIP},
+	})
+	if err != nil {
+		t.Fatalf("Send() voip error = %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+
+	live := requests[0]
+	if got := live.header.Get("apns-topic"); got != "com.example.app.push-type.liveactivity" {
+		t.Errorf("live activity apns-topic = %s", got)
+	}
+	if got := live.header.Get("apns-push-type"); got != "liveactivity" {
+		t.Errorf("live activity apns-push-type = %s", got)
+	}
+	aps := live.payload["aps"].(map[string]interface{})
+	if aps["event"] != "update" || aps["timestamp"] != float64(stale.Unix()-60) || aps["stale-date"] != float64(stale.Unix()) {
+		t.Errorf("live activity aps = %v", aps)
+	}
+	if state := aps["content-state"].(map[string]interface{}); state["eta_minutes"] != float64(3) {
+		t.Errorf("content-state = %v", state)
+	}
+	if _, found := aps["mutable-content"]; found {
+		t.Errorf("live activity aps has mutable-content")
+	}
+
+	voip := requests[1]
+	if voip.header.Get("apns-topic") != "com.example.app.voip" || voip.header.Get("apns-push-type") != "voip" || voip.header.Get("apns-priority") != "10" {
+		t.Errorf("voip headers = %v", voip.header)
+	}
+	if _, found := voip.payload["aps"].(map[string]interface{})["alert"]; found || voip.payload["call_id"] != "42" {
+		t.Errorf("voip payload = %v", voip.payload)
+	}
+
+	_, err = sender.Send(ctx, &Message{Tokens: []string{"a"}, APNs: &APNsOptions{PushType: APNsPushTypeLiveActivity}})
+	if !errors.Is(err, ErrMissingLiveActivity) {
+		t.Errorf("Send() liveactivity without options error = %v", err)
+	}
+}
+
+// testP8Key return a generated .p8 authentication key in PEM
+func testP8Key(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAPNsClientFromConfig_Keys(t *testing.T) {
+	key := testP8Key(t)
+	keyPath := filepath.Join(t.TempDir(), "key.p8")
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	newConfig := func(set func(config *APNsConfig)) *APNsConfig {
+		config := &APNsConfig{ID: "shop", KeyType: "p8", KeyID: "key-id", TeamID: "team-id", AppBundleID: "com.example.app"}
+		set(config)
+		return config
+	}
+	tests := []struct {
+		name    string
+		config  *APNsConfig
+		wantErr bool
+	}{
+		{"file", newConfig(func(c *APNsConfig) { c.KeyFilePath = keyPath }), false},
+		{"bytes", newConfig(func(c *APNsConfig) { c.KeyBytes = key }), false},
+		{"base64", newConfig(func(c *APNsConfig) { c.KeyBase64 = base64.StdEncoding.EncodeToString(key) }), false},
+		{"base64 type", newConfig(func(c *APNsConfig) {
+			c.KeyType = ""
+			c.KeyBase64Type = "p8"
+			c.KeyBase64 = base64.StdEncoding.EncodeToString(key)
+		}), false},
+		{"data", newConfig(func(c *APNsConfig) { c.KeyData = string(key) }), false},
+		{"bad base64", newConfig(func(c *APNsConfig) { c.KeyBase64 = "not base64!" }), true},
+		{"bad key", newConfig(func(c *APNsConfig) { c.KeyBytes = []byte("not a key") }), true},
+		{"no key", newConfig(func(c *APNsConfig) {}), true},
+	}
+	for _, tt := range tests {
+		client, err := APNsClientFromConfig(tt.config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: APNsClientFromConfig() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && client.Token == nil {
+			t.Errorf("%s: client without token", tt.name)
+		}
+		if problems := tt.config.validate(); (len(problems) > 0) != tt.wantErr {
+			t.Errorf("%s: validate() = %v, wantErr %v", tt.name, problems, tt.wantErr)
+		}
+	}
+
+	// key sources are never merged or picked silently
+	config := newConfig(func(c *APNsConfig) {
+		c.KeyFilePath = keyPath
+		c.KeyBase64 = base64.StdEncoding.EncodeToString(key)
+	})
+	if _, err := APNsClientFromConfig(config); err != ErrMultipleAPNsKeys {
+		t.Errorf("APNsClientFromConfig() with two keys error = %v, want %v", err, ErrMultipleAPNsKeys)
+	}
+	if problems := config.validate(); len(problems) != 1 {
+		t.Errorf("validate() with two keys = %v", problems)
+	}
+}
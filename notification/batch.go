@@ -0,0 +1,132 @@
+package notification
+
+import (
+	"context"
+	Sync "sync"
+)
+
+const (
+	// max tokens FCM accept in one multicast/batch request
+	FCMBatchSize = 500
+	// batches in flight for one message
+	defaultFCMBatchConcurrency = 4
+)
+
+// splitTokens split tokens into chunks of at most size tokens
+func splitTokens(tokens []string, size int) [][]string {
+	if size <= 0 || len(tokens) <= size {
+		return [][]string{tokens}
+	}
+	batches := make([][]string, 0, (len(tokens)+size-1)/size)
+	for start := 0; start < len(tokens); start += size {
+		end := start + size
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		batches = append(batches, tokens[start:end])
+	}
+	return batches
+}
+
+// sendBatches send msg.Tokens in batches of size tokens with at most
+// concurrency batches in flight, results are merged back in the order of
+// msg.Tokens. Tokens of a batch which return an error fail for platform.
+func sendBatches(ctx context.Context, platform int, msg *Message, size int, concurrency int,
+	send func(ctx context.Context, msg *Message) (*DeliveryReport, error)) (*DeliveryReport, error) {
+	batches := splitTokens(msg.Tokens, size)
+	if len(batches) == 1 {
+		return send(ctx, msg)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	reports := make([]*DeliveryReport, len(batches))
+	errs := make([]error, len(batches))
+	semaphore := make(chan struct{}, concurrency)
+	wg := &Sync.WaitGroup{}
+	for i, tokens := range batches {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, tokens []string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			batchMsg := *msg
+			batchMsg.Tokens = tokens
+			reports[i], errs[i] = send(ctx, &batchMsg)
+		}(i, tokens)
+	}
+	wg.Wait()
+
+	report := &DeliveryReport{}
+	for i, tokens := range batches {
+		if errs[i] != nil {
+			batchMsg := *msg
+			batchMsg.Tokens = tokens
+			report.merge(failAll(platform, &batchMsg, errs[i], false))
+			continue
+		}
+		report.merge(reports[i])
+	}
+	return report, nil
+}
+
+// sendEach call send for every token with at most concurrency calls in
+// flight, results are in the order of tokens
+func sendEach(tokens []string, concurrency int, send func(token string) TokenResult) []TokenResult {
+	results := make([]TokenResult, len(tokens))
+	jobs := make(chan int)
+	if concurrency > len(tokens) {
+		concurrency = len(tokens)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	wg := &Sync.WaitGroup{}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = send(tokens[i])
+			}
+		}()
+	}
+	for i := range tokens {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// requestLimiter bound requests in flight of a client, it is shared by every
+// batch and every Send so batches x workers never exceed it
+type requestLimiter chan struct{}
+
+func newRequestLimiter(limit int) requestLimiter {
+	return make(requestLimiter, limit)
+}
+
+// acquire wait for a free slot, it return ctx error when ctx is done first.
+// nil limiter does not limit.
+func (l requestLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l requestLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
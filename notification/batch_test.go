@@ -0,0 +1,171 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testTokens(n int) []string {
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token-%d", i)
+	}
+	return tokens
+}
+
+// inFlight count calls in flight and keep the max
+type inFlight struct {
+	current int32
+	max     int32
+}
+
+func (f *inFlight) enter() {
+	current := atomic.AddInt32(&f.current, 1)
+	for {
+		max := atomic.LoadInt32(&f.max)
+		if current <= max || atomic.CompareAndSwapInt32(&f.max, max, current) {
+			return
+		}
+	}
+}
+
+func (f *inFlight) leave() {
+	atomic.AddInt32(&f.current, -1)
+}
+
+func TestSplitTokens(t *testing.T) {
+	tests := []struct {
+		tokens int
+		size   int
+		want   []int
+	}{
+		{0, 500, []int{0}},
+		{3, 500, []int{3}},
+		{500, 500, []int{500}},
+		{501, 500, []int{500, 1}},
+		{1200, 500, []int{500, 500, 200}},
+		{3, 0, []int{3}},
+	}
+	for _, tt := range tests {
+		tokens := testTokens(tt.tokens)
+		batches := splitTokens(tokens, tt.size)
+		if len(batches) != len(tt.want) {
+			t.Fatalf("splitTokens(%d, %d) = %d batches, want %d", tt.tokens, tt.size, len(batches), len(tt.want))
+		}
+		next := 0
+		for i, batch := range batches {
+			if len(batch) != tt.want[i] {
+				t.Errorf("splitTokens(%d, %d) batch %d has %d tokens, want %d", tt.tokens, tt.size, i, len(batch), tt.want[i])
+			}
+			for _, token := range batch {
+				if token != tokens[next] {
+					t.Fatalf("splitTokens(%d, %d) token %s, want %s", tt.tokens, tt.size, token, tokens[next])
+				}
+				next++
+			}
+		}
+	}
+}
+
+func TestSendBatches(t *testing.T) {
+	tokens := testTokens(10)
+	errBatch := errors.New("batch failed")
+	flight := &inFlight{}
+	report, err := sendBatches(context.Background(), PLATFORM_APNs, &Message{Tokens: tokens}, 3, 2, func(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+		flight.enter()
+		defer flight.leave()
+		// later batches finish first, results must still keep token order
+		time.Sleep(time.Duration(10-len(msg.Tokens)) * time.Millisecond)
+		if msg.Tokens[0] == "token-3" {
+			return nil, errBatch
+		}
+		report := &DeliveryReport{}
+		for _, token := range msg.Tokens {
+			report.add(TokenResult{Token: token, Platform: PLATFORM_APNs, Status: StatusSent})
+		}
+		return report, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != len(tokens) {
+		t.Fatalf("%d results, want %d", len(report.Results), len(tokens))
+	}
+	for i, res := range report.Results {
+		if res.Token != tokens[i] || res.Platform != PLATFORM_APNs {
+			t.Errorf("result %d = %+v, want %s", i, res, tokens[i])
+		}
+		failed := i >= 3 && i < 6
+		if failed && (res.Status != StatusFailed || res.Err != errBatch) {
+			t.Errorf("result %d = %+v, want batch error", i, res)
+		}
+		if !failed && res.Status != StatusSent {
+			t.Errorf("result %d = %+v, want sent", i, res)
+		}
+	}
+	if flight.max > 2 {
+		t.Errorf("%d batches in flight, want at most 2", flight.max)
+	}
+}
+
+func TestSendEach(t *testing.T) {
+	tokens := testTokens(50)
+	flight := &inFlight{}
+	results := sendEach(tokens, 4, func(token string) TokenResult {
+		flight.enter()
+		defer flight.leave()
+		time.Sleep(time.Millisecond)
+		return TokenResult{Token: token, Status: StatusSent}
+	})
+	for i, res := range results {
+		if res.Token != tokens[i] {
+			t.Errorf("result %d = %s, want %s", i, res.Token, tokens[i])
+		}
+	}
+	if flight.max > 4 {
+		t.Errorf("%d calls in flight, want at most 4", flight.max)
+	}
+}
+
+func TestFCMV1Client_Concurrency(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	flight := &inFlight{}
+	fcmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flight.enter()
+		defer flight.leave()
+		time.Sleep(time.Millisecond)
+		_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+	}))
+	defer fcmServer.Close()
+	client, err := NewFCMV1Client(&FCMConfig{
+		ServiceAccountData: testServiceAccount(t, tokenServer.URL),
+		BaseURL:            fcmServer.URL,
+		BatchConcurrency:   4,
+		Concurrency:        8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 batches, each of them would run 8 workers without the client limit
+	report, err := client.Send(context.Background(), &Message{Title: "title", Tokens: testTokens(1200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SuccessCount() != 1200 {
+		t.Errorf("SuccessCount() = %d, want 1200", report.SuccessCount())
+	}
+	if flight.max > 8 {
+		t.Errorf("%d requests in flight, want at most 8", flight.max)
+	}
+}
@@ -0,0 +1,119 @@
+package notification
+
+import (
+	"context"
+	"strings"
+	Sync "sync"
+	"text/template"
+)
+
+//=================================================================
+// Channels
+// - Push (FCM, APNs, Web), email and SMS share the same Message
+// - Every recipient choose its channel, results are merged in one report
+//=================================================================
+
+// Recipient is one address of a channel: push token, email address or
+// phone number
+type Recipient struct {
+	Platform int
+	Address  string
+	// send through this client, empty use the first client of Platform
+	// (sorted by key)
+	ClientKey string
+	// language of device or user ("vi", "pt-BR"), used by RenderAndSend
+	Locale string
+	// owner of address and its IANA timezone, used by send policies
+	UserID   string
+	Timezone string
+}
+
+// ChannelTemplateData is data of email and SMS templates
+type ChannelTemplateData struct {
+	// email address or phone number of recipient
+	To    string
+	Title string
+	Body  string
+	// Message.PayloadData
+	Data interface{}
+}
+
+func isPushPlatform(platform int) bool {
+	return platform == PLATFORM_APNs || platform == PLATFORM_FCM || platform == PLATFORM_WebPush
+}
+
+// SendToRecipients send msg to msg.Recipients, each through the channel of
+// the recipient, msg.Tokens is ignored
+func (a *AppNotification) SendToRecipients(ctx context.Context, msg *Message) *DeliveryReport {
+	type route struct {
+		platform  int
+		clientKey string
+	}
+	var routes []route
+	addresses := map[route][]string{}
+	owners := map[string]tokenOwner{}
+	keys := a.ClientKeys()
+	for _, recipient := range msg.Recipients {
+		r := route{platform: recipient.Platform, clientKey: recipient.ClientKey}
+		if r.clientKey == "" {
+			r.clientKey = a.defaultClientKey(keys, recipient.Platform)
+		}
+		if _, found := addresses[r]; !found {
+			routes = append(routes, r)
+		}
+		addresses[r] = append(addresses[r], recipient.Address)
+		if recipient.UserID != "" || recipient.Timezone != "" {
+			owners[recipient.Address] = tokenOwner{userID: recipient.UserID, timezone: recipient.Timezone}
+		}
+	}
+
+	reports := make([]*DeliveryReport, len(routes))
+	wg := &Sync.WaitGroup{}
+	for i, r := range routes {
+		routeMsg := *msg
+		routeMsg.Tokens = addresses[r]
+		routeMsg.Recipients = nil
+		routeMsg.owners = owners
+		wg.Add(1)
+		go func(i int, r route, routeMsg *Message) {
+			defer wg.Done()
+			reports[i] = a.SendMessageCtx(ctx, r.platform, r.clientKey, routeMsg)
+		}(i, r, &routeMsg)
+	}
+	wg.Wait()
+
+	report := &DeliveryReport{}
+	for _, res := range reports {
+		report.merge(res)
+	}
+	return report
+}
+
+// defaultClientKey return the first key of keys registered for platform
+func (a *AppNotification) defaultClientKey(keys []string, platform int) string {
+	for _, key := range keys {
+		if client, found := a.Client(key); found && client.Platform == platform {
+			return key
+		}
+	}
+	return ""
+}
+
+// parseChannelTemplate parse text template of email and SMS config, empty
+// text use fallback
+func parseChannelTemplate(name string, text string, fallback string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = fallback
+	}
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// channelTemplateData return template data of msg for recipient to
+func channelTemplateData(msg *Message, to string) ChannelTemplateData {
+	return ChannelTemplateData{
+		To:    to,
+		Title: msg.Title,
+		Body:  msg.Body,
+		Data:  msg.PayloadData,
+	}
+}
@@ -0,0 +1,209 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	Sync "sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accept mails, RCPT of address containing "unknown" is
+// rejected with 550
+func fakeSMTPServer(t *testing.T) (host string, port int, mails func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mutex := &Sync.Mutex{}
+	var received []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				text := textproto.NewConn(conn)
+				_ = text.PrintfLine("220 localhost ESMTP")
+				for {
+					line, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+					switch {
+					case command == "EHLO" || command == "HELO":
+						_ = text.PrintfLine("250 localhost")
+					case command == "RCPT" && strings.Contains(line, "unknown"):
+						_ = text.PrintfLine("550 5.1.1 mailbox unavailable")
+					case command == "DATA":
+						_ = text.PrintfLine("354 go ahead")
+						data, _ := text.ReadDotBytes()
+						mutex.Lock()
+						received = append(received, string(data))
+						mutex.Unlock()
+						_ = text.PrintfLine("250 queued")
+					case command == "QUIT":
+						_ = text.PrintfLine("221 bye")
+						return
+					default:
+						_ = text.PrintfLine("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), received...)
+	}
+}
+
+func TestAppNotification_SendToRecipients(t *testing.T) {
+	host, port, mails := fakeSMTPServer(t)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["to"] == "+000" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body["text"] != "Order shipped: Order 1 is on the way" {
+			t.Errorf("sms text = %q", body["text"])
+		}
+		_, _ = w.Write([]byte(`{"data": {"id": 42}}`))
+	}))
+	defer gateway.Close()
+
+	a, err := NewAppNotification(Config{
+		EmailConfig: &EmailConfig{
+			ID:           "app",
+			Host:         host,
+			Port:         port,
+			From:         "Shop <no-reply@example.com>",
+			HTMLTemplate: "<p>{{.Body}}</p>",
+		},
+		SMSConfig: &SMSConfig{
+			ID:                 "app",
+			URL:                gateway.URL,
+			MessageIDField:     "data.id",
+			InvalidStatusCodes: []int{http.StatusBadRequest},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAppNotification() error = %v", err)
+	}
+	report := a.SendToRecipients(context.Background(), &Message{
+		Title: "Order shipped",
+		Body:  "Order 1 is on the way",
+		Recipients: []Recipient{
+			{Platform: PLATFORM_Email, Address: "user@example.com"},
+			{Platform: PLATFORM_SMS, Address: "+84900000000"},
+			{Platform: PLATFORM_Email, Address: "unknown@example.com"},
+			{Platform: PLATFORM_SMS, Address: "+000"},
+			{Platform: PLATFORM_FCM, Address: "token"},
+		},
+	})
+
+	want := map[string]DeliveryStatus{
+		"user@example.com": StatusSent,
+		// 550 is also used for spam and policy rejections
+		"unknown@example.com": StatusFailed,
+		"+84900000000":        StatusSent,
+		"+000":                StatusInvalidToken,
+		"token":               StatusFailed,
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("Results = %+v", report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Status != want[res.Token] {
+			t.Errorf("Results[%s].Status = %v, want %v (%v)", res.Token, res.Status, want[res.Token], res.Err)
+		}
+		if res.Token == "+84900000000" && res.MessageID != "42" {
+			t.Errorf("sms MessageID = %q", res.MessageID)
+		}
+	}
+	if got := mails(); len(got) != 1 ||
+		!strings.Contains(got[0], "multipart/alternative") ||
+		!strings.Contains(got[0], "<p>Order 1 is on the way</p>") ||
+		!strings.Contains(got[0], "Subject: Order shipped") {
+		t.Errorf("mails = %v", got)
+	}
+}
+
+func TestEmailClient_ReplyTo(t *testing.T) {
+	host, port, mails := fakeSMTPServer(t)
+	client, err := EmailClientFromConfig(&EmailConfig{Host: host, Port: port, From: "no-reply@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.Send(context.Background(), &Message{
+		Body:   "body",
+		Tokens: []string{"user@example.com"},
+		Email:  &EmailOptions{ReplyTo: "Support <support@example.com>"},
+	})
+	if err != nil || report.SuccessCount() != 1 {
+		t.Fatalf("Send() = %+v, %v", report, err)
+	}
+	if got := mails(); len(got) != 1 || !strings.Contains(got[0], "Reply-To: \"Support\" <support@example.com>\n") {
+		t.Errorf("mails = %q", got)
+	}
+
+	for _, replyTo := range []string{"support@example.com\r\nBcc: victim@example.com", "not an address"} {
+		_, err = client.Send(context.Background(), &Message{
+			Body:   "body",
+			Tokens: []string{"user@example.com"},
+			Email:  &EmailOptions{ReplyTo: replyTo},
+		})
+		if err == nil {
+			t.Errorf("Send() with reply to %q error = nil", replyTo)
+		}
+	}
+	if got := mails(); len(got) != 1 {
+		t.Errorf("%d mails sent, want 1", len(got))
+	}
+}
+
+func TestEmailClient_Cancel(t *testing.T) {
+	// server accept the connection but never answer the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	client, err := EmailClientFromConfig(&EmailConfig{Host: addr.IP.String(), Port: addr.Port, From: "no-reply@example.com", TLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	report, err := client.Send(ctx, &Message{Body: "body", Tokens: []string{"user@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FailureCount() != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("Send() after cancel = %+v in %s", report.Results, time.Since(start))
+	}
+}
@@ -0,0 +1,85 @@
+package notification
+
+import (
+	"context"
+)
+
+// Delivery is a handle of an async send
+type Delivery struct {
+	done   chan struct{}
+	report *DeliveryReport
+}
+
+// Done is closed when every provider call finished
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait block until the send finished and return its report
+func (d *Delivery) Wait() *DeliveryReport {
+	<-d.done
+	return d.report
+}
+
+// async run send in background and track it so Drain can wait for it, once
+// Drain was called every token of msg fail with ErrDraining
+func (a *AppNotification) async(msg *Message, send func() *DeliveryReport) *Delivery {
+	d := &Delivery{done: make(chan struct{})}
+	a.drainMutex.Lock()
+	if a.draining {
+		a.drainMutex.Unlock()
+		d.report = failAll(0, msg, ErrDraining, true)
+		close(d.done)
+		return d
+	}
+	a.inFlight.Add(1)
+	a.drainMutex.Unlock()
+	go func() {
+		defer a.inFlight.Done()
+		defer close(d.done)
+		d.report = send()
+	}()
+	return d
+}
+
+// SendMessageForAllAsync start SendMessageForAllCtx in background, cancel ctx
+// to abort it
+func (a *AppNotification) SendMessageForAllAsync(ctx context.Context, msg *Message) *Delivery {
+	return a.async(msg, func() *DeliveryReport {
+		return a.SendMessageForAllCtx(ctx, msg)
+	})
+}
+
+func (a *AppNotification) SendMessageForAndroidAsync(ctx context.Context, msg *Message) *Delivery {
+	return a.async(msg, func() *DeliveryReport {
+		return a.SendMessageForAndroidCtx(ctx, msg)
+	})
+}
+
+func (a *AppNotification) SendMessageForIOSAsync(ctx context.Context, msg *Message) *Delivery {
+	return a.async(msg, func() *DeliveryReport {
+		return a.SendMessageForIOSCtx(ctx, msg)
+	})
+}
+
+// Drain stop accepting async sends and wait for the running ones to finish,
+// usually called on shutdown. Async sends started after Drain fail with
+// ErrDraining. It return ctx.Err() if ctx is done first, sends keep running
+// in that case.
+func (a *AppNotification) Drain(ctx context.Context) error {
+	a.drainMutex.Lock()
+	a.draining = true
+	a.drainMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,94 @@
+package notification
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockSender block every send until release is closed or ctx is done
+type blockSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return failAll(PLATFORM_FCM, msg, ctx.Err(), false), nil
+	}
+	report := &DeliveryReport{}
+	for _, token := range msg.Tokens {
+		report.add(TokenResult{Token: token, Platform: PLATFORM_FCM, Status: StatusSent})
+	}
+	return report, nil
+}
+
+func newBlockApp(t *testing.T) (*AppNotification, *blockSender) {
+	a, _ := NewAppNotification(Config{})
+	sender := &blockSender{started: make(chan struct{}, 10), release: make(chan struct{})}
+	if err := a.AddClient(FCMClientKey("app"), NewClient(PLATFORM_FCM, "app", sender)); err != nil {
+		t.Fatal(err)
+	}
+	return a, sender
+}
+
+func TestAppNotification_Drain(t *testing.T) {
+	a, sender := newBlockApp(t)
+	delivery := a.SendMessageForAllAsync(context.Background(), &Message{Tokens: []string{"a"}})
+	<-sender.started
+	select {
+	case <-delivery.Done():
+		t.Fatal("Done() closed before send finished")
+	default:
+	}
+
+	// send is blocked, Drain give up when its ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := a.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// no async send is accepted once Drain started
+	rejected := a.SendMessageForAndroidAsync(context.Background(), &Message{Tokens: []string{"b"}}).Wait()
+	if len(rejected.Results) != 1 || rejected.Results[0].Err != ErrDraining || !rejected.Results[0].Retryable {
+		t.Errorf("results after Drain = %+v", rejected.Results)
+	}
+
+	close(sender.release)
+	if err := a.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() error = %v", err)
+	}
+	select {
+	case <-delivery.Done():
+	default:
+		t.Fatal("Drain() returned before send finished")
+	}
+	if report := delivery.Wait(); report.SuccessCount() != 1 {
+		t.Errorf("results = %+v", report.Results)
+	}
+}
+
+func TestAppNotification_AsyncCancel(t *testing.T) {
+	a, sender := newBlockApp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	delivery := a.SendMessageForIOSAsync(ctx, &Message{Tokens: []string{"a"}})
+	// no iOS client, the send finish without provider call
+	if report := delivery.Wait(); len(report.Results) != 0 {
+		t.Errorf("iOS results = %+v", report.Results)
+	}
+
+	delivery = a.SendMessageForAndroidAsync(ctx, &Message{Tokens: []string{"a", "b"}})
+	<-sender.started
+	cancel()
+	report := delivery.Wait()
+	if len(report.Results) != 2 || report.FailureCount() != 2 || report.Results[0].Err != context.Canceled {
+		t.Errorf("results after cancel = %+v", report.Results)
+	}
+	if err := a.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() error = %v", err)
+	}
+}
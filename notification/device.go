@@ -0,0 +1,292 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	Sync "sync"
+	"time"
+)
+
+//=================================================================
+// Device registry
+// - Keep token of every device with its owner, app, locale, timezone
+// - SendToUsers route tokens of users to the client of their app
+// - TokenInvalidator: dead tokens are removed from the registry
+// - TokenOwnerResolver: policies know user and timezone of tokens
+//=================================================================
+var (
+	ErrDeviceNotFound    = errors.New("notification: device not found")
+	ErrDeviceAppRequired = errors.New("notification: device app is required when platform has several clients")
+)
+
+type Device struct {
+	// push token, email address or phone number
+	Token    string
+	Platform int
+	// ID of app config, required when Platform has several clients, empty
+	// use the only client of Platform
+	AppID    string
+	UserID   string
+	Locale   string
+	Timezone string
+	LastSeen time.Time
+	// set by store when device is first registered
+	CreatedAt time.Time
+}
+
+// DeviceStore persist devices, a device is identified by platform and token
+type DeviceStore interface {
+	// Save insert device or update the one with the same platform and token
+	Save(ctx context.Context, device *Device) error
+	// Delete remove device, it is not an error if device does not exist
+	Delete(ctx context.Context, platform int, token string) error
+	// Get return device or ErrDeviceNotFound
+	Get(ctx context.Context, platform int, token string) (*Device, error)
+	// FindByUsers return devices of users
+	FindByUsers(ctx context.Context, userIDs []string) ([]*Device, error)
+}
+
+type DeviceRegistry struct {
+	store DeviceStore
+	now   func() time.Time
+	// number of clients of platform, set by AppNotification.SetDeviceRegistry
+	clientCount func(platform int) int
+}
+
+func NewDeviceRegistry(store DeviceStore) *DeviceRegistry {
+	return &DeviceRegistry{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Register save device and mark it as seen now, call it again on every app
+// start to refresh locale, timezone and last seen
+func (r *DeviceRegistry) Register(ctx context.Context, device Device) error {
+	if device.Token == "" {
+		return errors.New("notification: device token is required")
+	}
+	if device.AppID == "" && r.clientCount != nil && r.clientCount(device.Platform) > 1 {
+		return ErrDeviceAppRequired
+	}
+	device.LastSeen = r.now()
+	return r.store.Save(ctx, &device)
+}
+
+// Unregister remove device, ex: on logout
+func (r *DeviceRegistry) Unregister(ctx context.Context, platform int, token string) error {
+	return r.store.Delete(ctx, platform, token)
+}
+
+// Device return registered device
+func (r *DeviceRegistry) Device(ctx context.Context, platform int, token string) (*Device, error) {
+	return r.store.Get(ctx, platform, token)
+}
+
+// Devices return devices of users
+func (r *DeviceRegistry) Devices(ctx context.Context, userIDs []string) ([]*Device, error) {
+	return r.store.FindByUsers(ctx, userIDs)
+}
+
+// Recipients return a recipient for every device of users, routed to the
+// client of device app, it can be used with RenderAndSend
+func (r *DeviceRegistry) Recipients(ctx context.Context, userIDs []string) ([]Recipient, error) {
+	devices, err := r.store.FindByUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]Recipient, 0, len(devices))
+	for _, device := range devices {
+		recipients = append(recipients, Recipient{
+			Platform:  device.Platform,
+			Address:   device.Token,
+			ClientKey: clientKeyOf(device.Platform, device.AppID),
+			Locale:    device.Locale,
+			UserID:    device.UserID,
+			Timezone:  device.Timezone,
+		})
+	}
+	return recipients, nil
+}
+
+// InvalidateToken remove device of token reported as invalid by provider,
+// register registry with AppNotification.SetTokenInvalidator to use it
+func (r *DeviceRegistry) InvalidateToken(token string, platform int, reason string) {
+	if err := r.store.Delete(context.Background(), platform, token); err != nil {
+		getLogger().Error("notification: remove invalid token failed", "platform", platformName(platform), "token", token, "error", err)
+	}
+}
+
+// InvalidateAppToken remove device of token reported as invalid by a client
+// of appID, a device registered for another app is kept
+func (r *DeviceRegistry) InvalidateAppToken(token string, platform int, appID string, reason string) {
+	device, err := r.store.Get(context.Background(), platform, token)
+	if err != nil {
+		return
+	}
+	if device.AppID != "" && device.AppID != appID {
+		getLogger().Info("notification: keep token of another app", "platform", platformName(platform), "token", token, "app", device.AppID, "reported_by", appID)
+		return
+	}
+	// a device without app cannot be matched to one of several clients
+	if device.AppID == "" && r.clientCount != nil && r.clientCount(platform) > 1 {
+		getLogger().Info("notification: keep token without app", "platform", platformName(platform), "token", token, "reported_by", appID)
+		return
+	}
+	r.InvalidateToken(token, platform, reason)
+}
+
+// TokenOwner return user and timezone of registered token, register
+// registry with AppNotification.SetTokenOwnerResolver to use it
+func (r *DeviceRegistry) TokenOwner(ctx context.Context, platform int, token string) (string, string, bool) {
+	device, err := r.store.Get(ctx, platform, token)
+	if err != nil {
+		return "", "", false
+	}
+	return device.UserID, device.Timezone, true
+}
+
+// clientKeyOf return key of client created from app config of platform,
+// empty when appID is empty
+func clientKeyOf(platform int, appID string) string {
+	if appID == "" {
+		return ""
+	}
+	switch platform {
+	case PLATFORM_FCM:
+		return FCMClientKey(appID)
+	case PLATFORM_APNs:
+		return APNsClientKey(appID)
+	case PLATFORM_WebPush:
+		return WebPushClientKey(appID)
+	case PLATFORM_Email:
+		return EmailClientKey(appID)
+	case PLATFORM_SMS:
+		return SMSClientKey(appID)
+	}
+	return ""
+}
+
+// SetDeviceRegistry register device registry used by SendToUsers
+func (a *AppNotification) SetDeviceRegistry(devices *DeviceRegistry) {
+	if devices != nil {
+		devices.clientCount = a.platformClientCount
+	}
+	a.devices = devices
+}
+
+// platformClientCount return number of registered clients of platform
+func (a *AppNotification) platformClientCount(platform int) int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	count := 0
+	for _, client := range a.clients {
+		if client.Platform == platform {
+			count++
+		}
+	}
+	return count
+}
+
+// SendToUsers send msg to every registered device of users, msg.Tokens and
+// msg.Recipients are ignored. A device without app fails with
+// ErrDeviceAppRequired when its platform has several clients
+func (a *AppNotification) SendToUsers(ctx context.Context, userIDs []string, msg *Message) (*DeliveryReport, error) {
+	if a.devices == nil {
+		return nil, errors.New("notification: device registry is not set")
+	}
+	recipients, err := a.devices.Recipients(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	report := &DeliveryReport{}
+	routed := make([]Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		if recipient.ClientKey == "" && a.platformClientCount(recipient.Platform) > 1 {
+			report.add(TokenResult{
+				Token:    recipient.Address,
+				Platform: recipient.Platform,
+				Status:   StatusFailed,
+				Err:      ErrDeviceAppRequired,
+			})
+			continue
+		}
+		routed = append(routed, recipient)
+	}
+	userMsg := *msg
+	userMsg.Tokens = nil
+	userMsg.Recipients = routed
+	report.merge(a.SendToRecipients(ctx, &userMsg))
+	return report, nil
+}
+
+// MemoryDeviceStore keep devices in memory, for tests and prototypes
+type MemoryDeviceStore struct {
+	mutex   Sync.RWMutex
+	devices map[string]*Device
+}
+
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{devices: map[string]*Device{}}
+}
+
+func deviceKey(platform int, token string) string {
+	return strconv.Itoa(platform) + "|" + token
+}
+
+func (s *MemoryDeviceStore) Save(ctx context.Context, device *Device) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	saved := *device
+	if existing, found := s.devices[deviceKey(device.Platform, device.Token)]; found {
+		saved.CreatedAt = existing.CreatedAt
+	} else {
+		saved.CreatedAt = device.LastSeen
+	}
+	s.devices[deviceKey(device.Platform, device.Token)] = &saved
+	return nil
+}
+
+func (s *MemoryDeviceStore) Delete(ctx context.Context, platform int, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.devices, deviceKey(platform, token))
+	return nil
+}
+
+func (s *MemoryDeviceStore) Get(ctx context.Context, platform int, token string) (*Device, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	device, found := s.devices[deviceKey(platform, token)]
+	if !found {
+		return nil, ErrDeviceNotFound
+	}
+	out := *device
+	return &out, nil
+}
+
+func (s *MemoryDeviceStore) FindByUsers(ctx context.Context, userIDs []string) ([]*Device, error) {
+	users := map[string]bool{}
+	for _, userID := range userIDs {
+		users[userID] = true
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var devices []*Device
+	for _, device := range s.devices {
+		if users[device.UserID] {
+			out := *device
+			devices = append(devices, &out)
+		}
+	}
+	// stable order like a database ordered by user and creation
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].UserID != devices[j].UserID {
+			return devices[i].UserID < devices[j].UserID
+		}
+		return devices[i].CreatedAt.Before(devices[j].CreatedAt)
+	})
+	return devices, nil
+}
@@ -0,0 +1,133 @@
+package notification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GormDeviceStore keep devices in a SQL table through gorm
+type GormDeviceStore struct {
+	db *gorm.DB
+}
+
+// deviceRecord is the row of a device, platform and token are unique. Web
+// push tokens are long so the index is on the sha256 of the token, a long
+// varchar would be over the InnoDB key limit
+type deviceRecord struct {
+	ID        uint   `gorm:"primary_key"`
+	Token     string `gorm:"type:text;not null"`
+	TokenHash string `gorm:"type:char(64);not null;unique_index:idx_notification_device_token"`
+	Platform  int    `gorm:"not null;unique_index:idx_notification_device_token"`
+	AppID     string `gorm:"type:varchar(255)"`
+	UserID    string `gorm:"type:varchar(255);index"`
+	Locale    string `gorm:"type:varchar(35)"`
+	Timezone  string `gorm:"type:varchar(64)"`
+	LastSeen  time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (deviceRecord) TableName() string {
+	return "notification_devices"
+}
+
+func NewGormDeviceStore(db *gorm.DB) *GormDeviceStore {
+	return &GormDeviceStore{db: db}
+}
+
+// AutoMigrate create or update notification_devices table
+func (s *GormDeviceStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&deviceRecord{}).Error
+}
+
+func (s *GormDeviceStore) Save(ctx context.Context, device *Device) error {
+	values := map[string]interface{}{
+		"app_id":    device.AppID,
+		"user_id":   device.UserID,
+		"locale":    device.Locale,
+		"timezone":  device.Timezone,
+		"last_seen": device.LastSeen,
+	}
+	// look the row up, an update which change nothing report 0 rows on MySQL
+	record := &deviceRecord{}
+	err := s.db.Where("platform = ? AND token_hash = ?", device.Platform, tokenHash(device.Token)).First(record).Error
+	if err == nil {
+		return s.db.Model(record).Updates(values).Error
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	err = s.db.Create(&deviceRecord{
+		Token:     device.Token,
+		TokenHash: tokenHash(device.Token),
+		Platform:  device.Platform,
+		AppID:     device.AppID,
+		UserID:    device.UserID,
+		Locale:    device.Locale,
+		Timezone:  device.Timezone,
+		LastSeen:  device.LastSeen,
+	}).Error
+	if err == nil {
+		return nil
+	}
+	// registered by another request since the lookup, update that row
+	if s.db.Where("platform = ? AND token_hash = ?", device.Platform, tokenHash(device.Token)).First(record).Error != nil {
+		return err
+	}
+	return s.db.Model(record).Updates(values).Error
+}
+
+func (s *GormDeviceStore) Delete(ctx context.Context, platform int, token string) error {
+	return s.db.Where("platform = ? AND token_hash = ?", platform, tokenHash(token)).Delete(&deviceRecord{}).Error
+}
+
+func (s *GormDeviceStore) Get(ctx context.Context, platform int, token string) (*Device, error) {
+	record := &deviceRecord{}
+	err := s.db.Where("platform = ? AND token_hash = ?", platform, tokenHash(token)).First(record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.device(), nil
+}
+
+func (s *GormDeviceStore) FindByUsers(ctx context.Context, userIDs []string) ([]*Device, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	var records []*deviceRecord
+	err := s.db.Where("user_id IN (?)", userIDs).Order("user_id, created_at").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(records))
+	for _, record := range records {
+		devices = append(devices, record.device())
+	}
+	return devices, nil
+}
+
+func (r *deviceRecord) device() *Device {
+	return &Device{
+		Token:     r.Token,
+		Platform:  r.Platform,
+		AppID:     r.AppID,
+		UserID:    r.UserID,
+		Locale:    r.Locale,
+		Timezone:  r.Timezone,
+		LastSeen:  r.LastSeen,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+// tokenHash return hex sha256 of token, the indexed column of the token
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
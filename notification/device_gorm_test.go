@@ -0,0 +1,44 @@
+package notification
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestGormDeviceStore(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// every connection of :memory: is a new database
+	db.DB().SetMaxOpenConns(1)
+	store := NewGormDeviceStore(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	testDeviceStore(t, store)
+
+	var count int
+	db.Model(&deviceRecord{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d rows, want 2", count)
+	}
+
+	// web push subscription is longer than an indexable varchar
+	ctx := context.Background()
+	token := `{"endpoint":"https://push.example.com/` + strings.Repeat("x", 2000) + `"}`
+	if err := store.Save(ctx, &Device{Token: token, Platform: PLATFORM_WebPush, UserID: "u3"}); err != nil {
+		t.Fatalf("Save() long token error = %v", err)
+	}
+	if device, err := store.Get(ctx, PLATFORM_WebPush, token); err != nil || device.Token != token {
+		t.Errorf("Get() long token = %v, %v", device, err)
+	}
+	db.Model(&deviceRecord{}).Where("token_hash = ?", tokenHash(token)).Count(&count)
+	if count != 1 || len(tokenHash(token)) != 64 {
+		t.Errorf("%d rows with hash %s", count, tokenHash(token))
+	}
+}
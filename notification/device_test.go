@@ -0,0 +1,169 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppNotification_SendToUsers(t *testing.T) {
+	ctx := context.Background()
+	fcmApp1, fcmApp2, apns := &recordSender{}, &recordSender{}, &recordSender{}
+	a, _ := NewAppNotification(Config{})
+	_ = a.AddClient(FCMClientKey("app1"), NewClient(PLATFORM_FCM, "app1", fcmApp1))
+	_ = a.AddClient(FCMClientKey("app2"), NewClient(PLATFORM_FCM, "app2", fcmApp2))
+	_ = a.AddClient(APNsClientKey("ios"), NewClient(PLATFORM_APNs, "ios", apns))
+
+	if _, err := a.SendToUsers(ctx, []string{"u1"}, &Message{Title: "hi"}); err == nil {
+		t.Error("SendToUsers() without registry error = nil")
+	}
+
+	registry := NewDeviceRegistry(NewMemoryDeviceStore())
+	a.SetDeviceRegistry(registry)
+	a.SetTokenInvalidator(registry)
+	devices := []Device{
+		{Token: "u1-android", Platform: PLATFORM_FCM, AppID: "app2", UserID: "u1"},
+		{Token: "u1-iphone", Platform: PLATFORM_APNs, AppID: "ios", UserID: "u1", Timezone: "Asia/Tokyo"},
+		{Token: "u2-android", Platform: PLATFORM_FCM, AppID: "app1", UserID: "u2"},
+		{Token: "u3-android", Platform: PLATFORM_FCM, AppID: "app1", UserID: "u3"},
+		// only one APNs client, app can be omitted
+		{Token: "u2-iphone", Platform: PLATFORM_APNs, UserID: "u2"},
+	}
+	for _, device := range devices {
+		if err := registry.Register(ctx, device); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+	// register again only refresh the device
+	_ = registry.Register(ctx, Device{Token: "u2-android", Platform: PLATFORM_FCM, AppID: "app1", UserID: "u2", Locale: "vi"})
+	if device, _ := registry.Device(ctx, PLATFORM_FCM, "u2-android"); device.Locale != "vi" || device.LastSeen.IsZero() {
+		t.Errorf("Device() = %+v", device)
+	}
+	// 2 FCM clients, device must tell its app
+	if err := registry.Register(ctx, Device{Token: "u2-tablet", Platform: PLATFORM_FCM, UserID: "u2"}); err != ErrDeviceAppRequired {
+		t.Errorf("Register() without app error = %v, want %v", err, ErrDeviceAppRequired)
+	}
+	// device registered before the second client was added
+	_ = registry.store.Save(ctx, &Device{Token: "u2-tablet", Platform: PLATFORM_FCM, UserID: "u2"})
+
+	report, err := a.SendToUsers(ctx, []string{"u1", "u2"}, &Message{Title: "hi", Tokens: []string{"ignored"}})
+	if err != nil {
+		t.Fatalf("SendToUsers() error = %v", err)
+	}
+	if report.SuccessCount() != 4 || len(report.Results) != 5 {
+		t.Errorf("SendToUsers() results = %+v", report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Token == "u2-tablet" && res.Err != ErrDeviceAppRequired {
+			t.Errorf("device without app result = %+v", res)
+		}
+	}
+	if len(fcmApp1.messages) != 1 || fcmApp1.messages[0].Tokens[0] != "u2-android" {
+		t.Errorf("app1 messages = %+v", fcmApp1.messages)
+	}
+	if len(fcmApp2.messages) != 1 || fcmApp2.messages[0].Tokens[0] != "u1-android" {
+		t.Errorf("app2 messages = %+v", fcmApp2.messages)
+	}
+	if len(apns.messages) != 1 || len(apns.messages[0].Tokens) != 2 {
+		t.Errorf("apns messages = %+v", apns.messages)
+	}
+
+	if userID, timezone, found := registry.TokenOwner(ctx, PLATFORM_APNs, "u1-iphone"); !found || userID != "u1" || timezone != "Asia/Tokyo" {
+		t.Errorf("TokenOwner() = %s, %s, %v", userID, timezone, found)
+	}
+	registry.InvalidateToken("u1-iphone", PLATFORM_APNs, "Unregistered")
+	if _, err := registry.Device(ctx, PLATFORM_APNs, "u1-iphone"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Device() of invalidated token error = %v", err)
+	}
+	if err := registry.Unregister(ctx, PLATFORM_FCM, "u1-android"); err != nil {
+		t.Fatalf("Unregister() error = %v", err)
+	}
+	if devices, _ := registry.Devices(ctx, []string{"u1"}); len(devices) != 0 {
+		t.Errorf("Devices() after unregister = %+v", devices)
+	}
+}
+
+func TestDeviceRegistry_InvalidateAppToken(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeBackend()
+	a, err := NewAppNotification(Config{
+		AndroidConfigs: []*FCMConfig{{ID: "app1"}, {ID: "app2"}},
+	}, WithFakeBackend(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewDeviceRegistry(NewMemoryDeviceStore())
+	a.SetDeviceRegistry(registry)
+	a.SetTokenInvalidator(registry)
+	_ = registry.Register(ctx, Device{Token: "old", Platform: PLATFORM_FCM, AppID: "app2", UserID: "u1"})
+	_ = registry.store.Save(ctx, &Device{Token: "legacy", Platform: PLATFORM_FCM, UserID: "u1"})
+	fake.SetInvalidToken("old")
+	fake.SetInvalidToken("legacy")
+
+	// token of app2 reported by app1 is kept, so is a token without app
+	a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app1"), &Message{Tokens: []string{"old", "legacy"}})
+	if devices, _ := registry.Devices(ctx, []string{"u1"}); len(devices) != 2 {
+		t.Errorf("Devices() after app1 send = %+v", devices)
+	}
+	a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app2"), &Message{Tokens: []string{"old"}})
+	if _, err := registry.Device(ctx, PLATFORM_FCM, "old"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Device() of token invalidated by its app error = %v", err)
+	}
+}
+
+func TestMemoryDeviceStore(t *testing.T) {
+	testDeviceStore(t, NewMemoryDeviceStore())
+}
+
+// testDeviceStore check behaviour every DeviceStore must have
+func testDeviceStore(t *testing.T, store DeviceStore) {
+	ctx := context.Background()
+	first := time.Now().Add(-time.Hour).Truncate(time.Second)
+	device := &Device{Token: "a", Platform: PLATFORM_FCM, AppID: "app1", UserID: "u1", Locale: "en", LastSeen: first}
+	if err := store.Save(ctx, device); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// saving the same values again must not insert another device
+	if err := store.Save(ctx, device); err != nil {
+		t.Fatalf("Save() unchanged error = %v", err)
+	}
+	seen := first.Add(time.Hour)
+	if err := store.Save(ctx, &Device{Token: "a", Platform: PLATFORM_FCM, AppID: "app2", UserID: "u1", Locale: "vi", LastSeen: seen}); err != nil {
+		t.Fatalf("Save() update error = %v", err)
+	}
+	// same token on another platform is another device
+	_ = store.Save(ctx, &Device{Token: "a", Platform: PLATFORM_APNs, UserID: "u1", LastSeen: seen})
+	_ = store.Save(ctx, &Device{Token: "b", Platform: PLATFORM_FCM, UserID: "u2", LastSeen: seen})
+
+	got, err := store.Get(ctx, PLATFORM_FCM, "a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.AppID != "app2" || got.Locale != "vi" || !got.LastSeen.Equal(seen) || got.CreatedAt.IsZero() {
+		t.Errorf("Get() = %+v", got)
+	}
+	devices, err := store.FindByUsers(ctx, []string{"u1", "u2"})
+	if err != nil {
+		t.Fatalf("FindByUsers() error = %v", err)
+	}
+	if len(devices) != 3 || devices[0].UserID != "u1" || devices[2].Token != "b" {
+		t.Errorf("FindByUsers() = %+v", devices)
+	}
+	if devices, _ := store.FindByUsers(ctx, nil); len(devices) != 0 {
+		t.Errorf("FindByUsers(nil) = %+v", devices)
+	}
+
+	if err := store.Delete(ctx, PLATFORM_FCM, "a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, PLATFORM_FCM, "a"); err != nil {
+		t.Errorf("Delete() of missing device error = %v", err)
+	}
+	if _, err := store.Get(ctx, PLATFORM_FCM, "a"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Get() of deleted device error = %v", err)
+	}
+	if _, err := store.Get(ctx, PLATFORM_APNs, "a"); err != nil {
+		t.Errorf("Get() of APNs device error = %v", err)
+	}
+}
@@ -0,0 +1,379 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//=================================================================
+// Email channel - SMTP
+// - Subject, text and html body are rendered from templates of config
+// - One mail per recipient, so addresses are not shared
+//=================================================================
+const (
+	defaultSMTPPort         = 587
+	defaultSMTPTimeout      = time.Minute
+	defaultEmailConcurrency = 4
+	defaultEmailSubject     = "{{.Title}}"
+	defaultEmailText        = "{{.Body}}"
+)
+
+type EmailConfig struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	Host   string `json:"host"`
+	// default 587
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// "Shop <no-reply@example.com>"
+	From string `json:"from"`
+	// implicit TLS (usually port 465), otherwise STARTTLS is used when
+	// server support it
+	TLS bool `json:"tls"`
+	// go templates rendered with ChannelTemplateData, subject default to
+	// Title, text default to Body, no html part when html template is empty
+	SubjectTemplate  string `json:"subject_template"`
+	TextTemplate     string `json:"text_template"`
+	TextTemplateFile string `json:"text_template_file"`
+	HTMLTemplate     string `json:"html_template"`
+	HTMLTemplateFile string `json:"html_template_file"`
+	// number of concurrent SMTP sessions, default 4
+	Concurrency int `json:"concurrency"`
+}
+
+// EmailOptions override email fields of message
+type EmailOptions struct {
+	// used instead of subject template
+	Subject string
+	ReplyTo string
+}
+
+// emailOptions return email options of msg, never nil
+func (m *Message) emailOptions() *EmailOptions {
+	if m.Email != nil {
+		return m.Email
+	}
+	return &EmailOptions{}
+}
+
+type EmailClient struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	TLS         bool
+	Concurrency int
+
+	from    *mail.Address
+	subject *template.Template
+	text    *template.Template
+	html    *htmlTemplate.Template
+}
+
+// EmailClientFromConfig create SMTP client and parse templates of config
+func EmailClientFromConfig(config *EmailConfig) (*EmailClient, error) {
+	if config.Host == "" {
+		return nil, errors.New("platform Email: host is required")
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("platform Email: invalid from: %w", err)
+	}
+	client := &EmailClient{
+		Host:        config.Host,
+		Port:        config.Port,
+		Username:    config.Username,
+		Password:    config.Password,
+		From:        config.From,
+		TLS:         config.TLS,
+		Concurrency: config.Concurrency,
+		from:        from,
+	}
+	if client.Port <= 0 {
+		client.Port = defaultSMTPPort
+	}
+	if client.Concurrency <= 0 {
+		client.Concurrency = defaultEmailConcurrency
+	}
+
+	if client.subject, err = parseChannelTemplate("subject", config.SubjectTemplate, defaultEmailSubject); err != nil {
+		return nil, fmt.Errorf("platform Email: subject_template: %w", err)
+	}
+	text, err := templateText(config.TextTemplate, config.TextTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("platform Email: text_template_file: %w", err)
+	}
+	if client.text, err = parseChannelTemplate("text", text, defaultEmailText); err != nil {
+		return nil, fmt.Errorf("platform Email: text_template: %w", err)
+	}
+	html, err := templateText(config.HTMLTemplate, config.HTMLTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("platform Email: html_template_file: %w", err)
+	}
+	if strings.TrimSpace(html) != "" {
+		if client.html, err = htmlTemplate.New("html").Option("missingkey=zero").Parse(html); err != nil {
+			return nil, fmt.Errorf("platform Email: html_template: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// templateText return text, or content of path when text is empty
+func templateText(text string, path string) (string, error) {
+	if text != "" || path == "" {
+		return text, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	return string(data), err
+}
+
+func (c *EmailClient) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	opts := msg.emailOptions()
+	replyTo, err := parseReplyTo(opts.ReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	results := sendEach(msg.Tokens, c.Concurrency, func(to string) TokenResult {
+		return c.sendOne(ctx, to, msg, opts, replyTo)
+	})
+	return &DeliveryReport{Results: results}, nil
+}
+
+// parseReplyTo parse Reply-To option, nil when it is empty. CR/LF are
+// rejected so the option can not add headers.
+func parseReplyTo(replyTo string) (*mail.Address, error) {
+	if replyTo == "" {
+		return nil, nil
+	}
+	if strings.ContainsAny(replyTo, "\r\n") {
+		return nil, errors.New("platform Email: reply_to must not contain line breaks")
+	}
+	address, err := mail.ParseAddress(replyTo)
+	if err != nil {
+		return nil, fmt.Errorf("platform Email: parse reply_to: %w", err)
+	}
+	return address, nil
+}
+
+func (c *EmailClient) sendOne(ctx context.Context, to string, msg *Message, opts *EmailOptions, replyTo *mail.Address) TokenResult {
+	result := TokenResult{
+		Token:    to,
+		Platform: PLATFORM_Email,
+	}
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		result.Status = StatusInvalidToken
+		result.Reason = "InvalidAddress"
+		result.Err = err
+		return result
+	}
+	body, messageID, err := c.buildMail(address, msg, opts, replyTo)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Reason = "InvalidTemplate"
+		result.Err = err
+		return result
+	}
+	if err := c.sendMail(ctx, address.Address, body); err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+		protoErr := &textproto.Error{}
+		if !errors.As(err, &protoErr) {
+			// connection or TLS problem
+			result.Retryable = ctx.Err() == nil
+			return result
+		}
+		result.StatusCode = protoErr.Code
+		result.Reason = protoErr.Msg
+		// 5xx are permanent but also used for policy, spam and quota
+		// rejections, so the address is not invalidated
+		result.Retryable = protoErr.Code >= 400 && protoErr.Code < 500
+		return result
+	}
+	result.Status = StatusSent
+	result.MessageID = messageID
+	return result
+}
+
+// buildMail render templates to a MIME message and return it with its
+// Message-ID, body is multipart/alternative when html template is set
+func (c *EmailClient) buildMail(to *mail.Address, msg *Message, opts *EmailOptions, replyTo *mail.Address) ([]byte, string, error) {
+	data := channelTemplateData(msg, to.Address)
+	subject := opts.Subject
+	if subject == "" {
+		buf := &bytes.Buffer{}
+		if err := c.subject.Execute(buf, data); err != nil {
+			return nil, "", err
+		}
+		subject = strings.TrimSpace(buf.String())
+	}
+	text := &bytes.Buffer{}
+	if err := c.text.Execute(text, data); err != nil {
+		return nil, "", err
+	}
+	messageID, err := newMessageID(c.from.Address)
+	if err != nil {
+		return nil, "", err
+	}
+
+	header := [][2]string{
+		{"From", c.from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+	}
+	if replyTo != nil {
+		header = append(header, [2]string{"Reply-To", replyTo.String()})
+	}
+	content := &bytes.Buffer{}
+	if c.html == nil {
+		header = append(header,
+			[2]string{"Content-Type", "text/plain; charset=utf-8"},
+			[2]string{"Content-Transfer-Encoding", "quoted-printable"})
+		if err := writeQuotedPrintable(content, text.Bytes()); err != nil {
+			return nil, "", err
+		}
+	} else {
+		html := &bytes.Buffer{}
+		if err := c.html.Execute(html, data); err != nil {
+			return nil, "", err
+		}
+		writer := multipart.NewWriter(content)
+		header = append(header, [2]string{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()})
+		for _, part := range []struct {
+			contentType string
+			content     []byte
+		}{
+			{"text/plain; charset=utf-8", text.Bytes()},
+			{"text/html; charset=utf-8", html.Bytes()},
+		} {
+			w, err := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, "", err
+			}
+			if err := writeQuotedPrintable(w, part.content); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	for _, field := range header {
+		fmt.Fprintf(buf, "%s: %s\r\n", field[0], field[1])
+	}
+	buf.WriteString("\r\n")
+	buf.Write(content.Bytes())
+	return buf.Bytes(), messageID, nil
+}
+
+func writeQuotedPrintable(w io.Writer, content []byte) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID create a unique Message-ID at the domain of from
+func newMessageID(from string) (string, error) {
+	domain := from[strings.LastIndex(from, "@")+1:]
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%x.%d@%s>", random, time.Now().UnixNano(), domain), nil
+}
+
+// sendMail deliver body to one recipient in its own SMTP session, ctx
+// deadline is applied to the whole session
+func (c *EmailClient) sendMail(ctx context.Context, to string, body []byte) error {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer rawConn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPTimeout)
+	}
+	_ = rawConn.SetDeadline(deadline)
+	// net/smtp does not take ctx, close the TCP connection to abort the
+	// session, it is never reassigned so TLS wrapping does not race with it
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rawConn.Close()
+		case <-stop:
+		}
+	}()
+	conn := rawConn
+	if c.TLS {
+		conn = tls.Client(rawConn, &tls.Config{ServerName: c.Host})
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// mail is accepted, error of QUIT does not matter
+	_ = client.Quit()
+	return nil
+}
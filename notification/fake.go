@@ -0,0 +1,164 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	Sync "sync"
+	"time"
+)
+
+//=================================================================
+// Fake backend
+// - In-memory sender for tests of code using AppNotification
+// - Record every delivery per token, no provider is called
+// - Per-token results can be programmed (invalid token, rate limited...)
+//=================================================================
+
+// FakeDelivery is one token of a message sent through FakeBackend
+type FakeDelivery struct {
+	Platform int
+	AppID    string
+	Token    string
+	// copy of sent message with Tokens set to Token only
+	Message *Message
+	Result  TokenResult
+}
+
+// FakeBackend record messages instead of sending them, use it with
+// WithFakeBackend or NewClient(platform, appID, fake.Sender(platform, appID))
+type FakeBackend struct {
+	mutex      Sync.Mutex
+	deliveries []FakeDelivery
+	// programmed result per token, token not in map is sent
+	results map[string]TokenResult
+	// returned by every Send when set, like provider is down
+	sendErr error
+}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{results: map[string]TokenResult{}}
+}
+
+// SetResult make every send to token return result, Token and Platform of
+// result are filled by the backend
+func (f *FakeBackend) SetResult(token string, result TokenResult) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.results[token] = result
+}
+
+// SetInvalidToken make token be reported as unregistered by provider
+func (f *FakeBackend) SetInvalidToken(token string) {
+	f.SetResult(token, TokenResult{
+		Status:     StatusInvalidToken,
+		StatusCode: http.StatusGone,
+		Reason:     "Unregistered",
+	})
+}
+
+// SetRateLimited make token be rejected with 429, retryAfter is the delay
+// asked by provider (zero for none)
+func (f *FakeBackend) SetRateLimited(token string, retryAfter time.Duration) {
+	f.SetResult(token, TokenResult{
+		Status:     StatusFailed,
+		StatusCode: http.StatusTooManyRequests,
+		Reason:     "TooManyRequests",
+		Retryable:  true,
+		RetryAfter: retryAfter,
+	})
+}
+
+// ClearResult make token be sent again
+func (f *FakeBackend) ClearResult(token string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.results, token)
+}
+
+// SetSendError make every send fail with err, nil restore sending
+func (f *FakeBackend) SetSendError(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sendErr = err
+}
+
+// Deliveries return every recorded delivery in send order, failed ones
+// included
+func (f *FakeBackend) Deliveries() []FakeDelivery {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]FakeDelivery(nil), f.deliveries...)
+}
+
+// Messages return messages successfully sent to token
+func (f *FakeBackend) Messages(token string) []*Message {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var messages []*Message
+	for _, delivery := range f.deliveries {
+		if delivery.Token == token && delivery.Result.Sent() {
+			messages = append(messages, delivery.Message)
+		}
+	}
+	return messages
+}
+
+// Reset remove recorded deliveries and programmed results
+func (f *FakeBackend) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deliveries = nil
+	f.results = map[string]TokenResult{}
+	f.sendErr = nil
+}
+
+// Sender return sender of one client recording to f
+func (f *FakeBackend) Sender(platform int, appID string) Sender {
+	return &fakeSender{backend: f, platform: platform, appID: appID}
+}
+
+type fakeSender struct {
+	backend  *FakeBackend
+	platform int
+	appID    string
+}
+
+func (s *fakeSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	f := s.backend
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tokens := msg.Tokens
+	if msg.IsTopicMessage() {
+		tokens = []string{msg.topicTarget()}
+	}
+	report := &DeliveryReport{}
+	for _, token := range tokens {
+		result, found := f.results[token]
+		if !found {
+			result = TokenResult{Status: StatusSent, StatusCode: http.StatusOK}
+		}
+		result.Token = token
+		result.Platform = s.platform
+		if result.Sent() && result.MessageID == "" {
+			result.MessageID = "fake-" + strconv.Itoa(len(f.deliveries)+1)
+		}
+		sent := *msg
+		sent.Tokens = []string{token}
+		f.deliveries = append(f.deliveries, FakeDelivery{
+			Platform: s.platform,
+			AppID:    s.appID,
+			Token:    token,
+			Message:  &sent,
+			Result:   result,
+		})
+		report.add(result)
+	}
+	return report, nil
+}
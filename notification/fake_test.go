@@ -0,0 +1,60 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	Sync "sync"
+	"testing"
+)
+
+func TestWithFakeBackend(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeBackend()
+	// no credentials, configs are not validated with a fake backend
+	a, err := NewAppNotification(Config{
+		AndroidConfigs: []*FCMConfig{{ID: "shop", Tenant: "acme"}},
+		IOSConfigs:     []*APNsConfig{{ID: "shop-ios", Tenant: "acme"}},
+		Retry:          &RetryPolicy{MaxAttempts: 2, BaseDelayMs: 1},
+	}, WithFakeBackend(fake))
+	if err != nil {
+		t.Fatalf("NewAppNotification() error = %v", err)
+	}
+	var (
+		mutex       Sync.Mutex
+		invalidated []string
+	)
+	a.SetTokenInvalidator(TokenInvalidatorFunc(func(token string, platform int, reason string) {
+		mutex.Lock()
+		invalidated = append(invalidated, token)
+		mutex.Unlock()
+	}))
+
+	fake.SetInvalidToken("old")
+	fake.SetRateLimited("busy", 0)
+	report := a.SendToTenant(ctx, "acme", &Message{Title: "hi", Tokens: []string{"a", "old", "busy"}})
+	if report.SuccessCount() != 2 || report.FailureCount() != 4 {
+		t.Errorf("SuccessCount() = %d, FailureCount() = %d", report.SuccessCount(), report.FailureCount())
+	}
+	for _, res := range report.Results {
+		if res.Token == "busy" && (res.Attempts != 2 || res.StatusCode != 429) {
+			t.Errorf("rate limited result = %+v", res)
+		}
+	}
+	if len(invalidated) != 1 || invalidated[0] != "old" {
+		t.Errorf("invalidated = %v", invalidated)
+	}
+	if messages := fake.Messages("a"); len(messages) != 2 || messages[0].Title != "hi" || len(messages[0].Tokens) != 1 {
+		t.Errorf("Messages(a) = %+v", messages)
+	}
+	// 2 clients x (a + old + busy twice)
+	if got := len(fake.Deliveries()); got != 8 {
+		t.Errorf("len(Deliveries()) = %d, want 8", got)
+	}
+
+	fake.Reset()
+	fake.SetSendError(errors.New("provider down"))
+	report = a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("shop"), &Message{Tokens: []string{"a"}})
+	if report.FailureCount() != 1 || len(fake.Deliveries()) != 0 {
+		t.Errorf("send with provider down = %+v", report.Results)
+	}
+}
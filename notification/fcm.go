@@ -0,0 +1,230 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	Sync "sync"
+
+	// android - FCM
+	"github.com/NaySoftware/go-fcm"
+)
+
+var ErrMissingFCMCredential = errors.New("platform FCM: server_key or service account is required")
+
+//=================================================================
+// Android service
+// - Support server key (legacy API, shut down by Google)
+// - Support service account for HTTP v1 API, see fcm_v1.go
+//=================================================================
+type FCMConfig struct {
+	ID        string `json:"id"`
+	Tenant    string `json:"tenant"`
+	ServerKey string `json:"server_key"`
+	ClientID  string `json:"client_id"`
+	// HTTP v1 API, service account json from file path or inline data
+	ProjectID          string `json:"project_id"`
+	ServiceAccountFile string `json:"service_account_file"`
+	ServiceAccountData string `json:"service_account_data"`
+	// override endpoints, used for testing
+	BaseURL    string `json:"base_url"`
+	TokenURL   string `json:"token_url"`
+	IIDBaseURL string `json:"iid_base_url"`
+	// batches of FCMBatchSize tokens sent concurrently, default 4
+	BatchConcurrency int `json:"batch_concurrency"`
+	// HTTP v1 requests in flight for the client across every batch and
+	// send, default 50
+	Concurrency int `json:"concurrency"`
+}
+
+// UseV1 return true when service account was configured
+func (c *FCMConfig) UseV1() bool {
+	return c.ServiceAccountFile != "" || c.ServiceAccountData != ""
+}
+
+// FCMSenderFromConfig create HTTP v1 sender when service account was
+// configured, fall back to legacy server key otherwise
+func FCMSenderFromConfig(config *FCMConfig) (Sender, error) {
+	if config.UseV1() {
+		return NewFCMV1Client(config)
+	}
+	if config.ServerKey == "" {
+		return nil, ErrMissingFCMCredential
+	}
+	return NewFCMSender(fcm.NewFcmClient(config.ServerKey)), nil
+}
+
+func FCMInitFromConfig(config *FCMConfig) *fcm.FcmClient {
+	if config.ServerKey == "" {
+		getLogger().Error("platform FCM: init FCM failed, server key was empty", "app", config.ID)
+		return nil
+	}
+	client := fcm.NewFcmClient(config.ServerKey)
+	return client
+}
+
+// FCM error codes which mean the registration token will never work again,
+// InvalidRegistration and MismatchSenderId are returned for tokens of other
+// apps or platforms so they are failures
+var fcmInvalidTokenReasons = map[string]bool{
+	"NotRegistered": true,
+}
+
+// FCM error codes which can be retried later
+var fcmRetryableReasons = map[string]bool{
+	"Unavailable":               true,
+	"InternalServerError":       true,
+	"DeviceMessageRateExceeded": true,
+}
+
+type fcmSender struct {
+	// go-fcm keep message on client so every send must be serialized
+	mutex  *Sync.Mutex
+	client *fcm.FcmClient
+}
+
+// NewFCMSender wrap a go-fcm client as Sender
+func NewFCMSender(client *fcm.FcmClient) Sender {
+	return &fcmSender{
+		mutex:  &Sync.Mutex{},
+		client: client,
+	}
+}
+
+func (s *fcmSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	// client is serialized by mutex so batches are sent one by one
+	return sendBatches(ctx, PLATFORM_FCM, msg, FCMBatchSize, 1, s.sendBatch)
+}
+
+func (s *fcmSender) sendBatch(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	msgData, err := encodePayloadData(PLATFORM_FCM, msg.PayloadData)
+	if err != nil {
+		return nil, err
+	}
+	// keep data nil so it is omitted from request
+	var data interface{}
+	if msgData != nil {
+		encoded, _ := json.Marshal(msgData)
+		if err := checkPayloadSize(PLATFORM_FCM, encoded, FCMMaxPayloadSize); err != nil {
+			return nil, err
+		}
+		data = msgData
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prepare(msg, data)
+	status, err := s.client.Send()
+	if err != nil {
+		return failAll(PLATFORM_FCM, msg, err, true), nil
+	}
+	if msg.IsTopicMessage() {
+		return fcmTopicReport(msg, status), nil
+	}
+	return fcmReport(msg, status), nil
+}
+
+// prepare set message of go-fcm client, caller must hold mutex
+func (s *fcmSender) prepare(msg *Message, data interface{}) {
+	// go-fcm only set fields, clear to/condition/registration_ids of the
+	// previous send
+	s.client.Message = fcm.FcmMsg{}
+	opts := msg.androidOptions()
+	priority := opts.Priority
+	if priority == "" {
+		priority = "high"
+	}
+	s.client.SetPriority(priority)
+	if msg.IsTopicMessage() {
+		if msg.Condition != "" {
+			s.client.NewFcmMsgTo("", data)
+			s.client.SetCondition(msg.Condition)
+		} else {
+			s.client.NewFcmMsgTo(msg.topicTarget(), data)
+		}
+	} else {
+		s.client.NewFcmRegIdsMsg(msg.Tokens, data)
+	}
+	apnsOpts := msg.apnsOptions()
+	s.client.SetCollapseKey(opts.CollapseKey)
+	s.client.SetTimeToLive(int(opts.TTL.Seconds()))
+	s.client.SetContentAvailable(apnsOpts.ContentAvailable)
+	s.client.SetNotificationPayload(&fcm.NotificationPayload{
+		Title:        msg.Title,
+		Body:         msg.Body,
+		Sound:        msg.Sound,
+		Badge:        msg.Badge,
+		Icon:         opts.Icon,
+		Color:        opts.Color,
+		ClickAction:  opts.ClickAction,
+		TitleLocKey:  apnsOpts.TitleLocKey,
+		TitleLocArgs: jsonArgs(apnsOpts.TitleLocArgs),
+		BodyLocKey:   apnsOpts.BodyLocKey,
+		BodyLocArgs:  jsonArgs(apnsOpts.BodyLocArgs),
+	})
+}
+
+// fcmTopicReport map legacy FCM response of topic message, it has only
+// message_id or error
+func fcmTopicReport(msg *Message, status *fcm.FcmResponseStatus) *DeliveryReport {
+	res := TokenResult{
+		Token:      msg.topicTarget(),
+		Platform:   PLATFORM_FCM,
+		StatusCode: status.StatusCode,
+		Reason:     status.Err,
+	}
+	if status.StatusCode == http.StatusOK && status.Err == "" {
+		res.Status = StatusSent
+		res.MessageID = strconv.FormatInt(status.MsgId, 10)
+	} else {
+		res.Status = StatusFailed
+		res.Retryable = status.StatusCode >= http.StatusInternalServerError || fcmRetryableReasons[status.Err]
+		res.RetryAfter, _ = status.GetRetryAfterTime()
+	}
+	return &DeliveryReport{Results: []TokenResult{res}}
+}
+
+// fcmReport map legacy FCM response to per token results, FCM return
+// results in the same order of registration_ids
+func fcmReport(msg *Message, status *fcm.FcmResponseStatus) *DeliveryReport {
+	retryAfter, _ := status.GetRetryAfterTime()
+	report := &DeliveryReport{}
+	for i, token := range msg.Tokens {
+		res := TokenResult{
+			Token:      token,
+			Platform:   PLATFORM_FCM,
+			StatusCode: status.StatusCode,
+		}
+		if status.StatusCode != http.StatusOK || i >= len(status.Results) {
+			// whole request was rejected
+			res.Status = StatusFailed
+			res.Reason = status.Err
+			if res.Reason == "" {
+				res.Reason = http.StatusText(status.StatusCode)
+			}
+			res.Retryable = status.StatusCode >= http.StatusInternalServerError
+			res.RetryAfter = retryAfter
+			report.add(res)
+			continue
+		}
+		result := status.Results[i]
+		res.MessageID = result["message_id"]
+		res.Reason = result["error"]
+		switch {
+		case res.Reason == "":
+			res.Status = StatusSent
+		case fcmInvalidTokenReasons[res.Reason]:
+			res.Status = StatusInvalidToken
+		default:
+			res.Status = StatusFailed
+			res.Retryable = fcmRetryableReasons[res.Reason]
+			res.RetryAfter = retryAfter
+		}
+		report.add(res)
+	}
+	return report
+}
@@ -0,0 +1,389 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+//=================================================================
+// Android service - FCM HTTP v1 API
+// - Support service account json (file path or inline data)
+//=================================================================
+const (
+	FCMV1BaseURL = "https://fcm.googleapis.com"
+	fcmV1Scope   = "https://www.googleapis.com/auth/firebase.messaging"
+	// v1 API accept one token per request, requests in flight of a client
+	defaultFCMV1Concurrency = 50
+)
+
+// FCM v1 error codes which mean the registration token will never work
+// again, SENDER_ID_MISMATCH is a token of another project so it is a failure
+var fcmV1InvalidTokenReasons = map[string]bool{
+	"UNREGISTERED": true,
+}
+
+// FCM v1 error codes which can be retried later
+var fcmV1RetryableReasons = map[string]bool{
+	"UNAVAILABLE":    true,
+	"INTERNAL":       true,
+	"QUOTA_EXCEEDED": true,
+}
+
+// FCMV1Client send message through projects/{id}/messages:send with an
+// OAuth2 access token minted from service account
+type FCMV1Client struct {
+	ProjectID        string
+	BaseURL          string
+	IIDBaseURL       string
+	BatchConcurrency int
+
+	httpClient *http.Client
+	// bound requests of every batch and Send, see FCMConfig.Concurrency
+	limiter requestLimiter
+}
+
+// NewFCMV1Client create FCM HTTP v1 client from config, service account is
+// read from ServiceAccountFile or ServiceAccountData. Access tokens are cached
+// and refreshed only when expired.
+func NewFCMV1Client(config *FCMConfig) (*FCMV1Client, error) {
+	data := []byte(config.ServiceAccountData)
+	if config.ServiceAccountFile != "" {
+		var err error
+		data, err = ioutil.ReadFile(config.ServiceAccountFile)
+		if err != nil {
+			return nil, fmt.Errorf("notification: read FCM service account: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, errors.New("notification: FCM service account was empty")
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(data, fcmV1Scope)
+	if err != nil {
+		return nil, fmt.Errorf("notification: parse FCM service account: %w", err)
+	}
+	if config.TokenURL != "" {
+		jwtConfig.TokenURL = config.TokenURL
+	}
+
+	projectID := config.ProjectID
+	if projectID == "" {
+		account := struct {
+			ProjectID string `json:"project_id"`
+		}{}
+		_ = json.Unmarshal(data, &account)
+		projectID = account.ProjectID
+	}
+	if projectID == "" {
+		return nil, errors.New("notification: FCM project id was empty")
+	}
+
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = FCMV1BaseURL
+	}
+	iidBaseURL := config.IIDBaseURL
+	if iidBaseURL == "" {
+		iidBaseURL = FCMIIDBaseURL
+	}
+	batchConcurrency := config.BatchConcurrency
+	if batchConcurrency <= 0 {
+		batchConcurrency = defaultFCMBatchConcurrency
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultFCMV1Concurrency
+	}
+	return &FCMV1Client{
+		ProjectID:        projectID,
+		BaseURL:          strings.TrimRight(baseURL, "/"),
+		IIDBaseURL:       strings.TrimRight(iidBaseURL, "/"),
+		BatchConcurrency: batchConcurrency,
+		httpClient: &http.Client{
+			Transport: &oauth2.Transport{
+				// ReuseTokenSource inside jwt config keep token until it expired
+				Source: jwtConfig.TokenSource(context.Background()),
+				Base:   http.DefaultTransport,
+			},
+			Timeout: 30 * time.Second,
+		},
+		limiter: newRequestLimiter(concurrency),
+	}, nil
+}
+
+type fcmV1Request struct {
+	Message fcmV1Message `json:"message"`
+}
+
+type fcmV1Message struct {
+	Token        string              `json:"token,omitempty"`
+	Topic        string              `json:"topic,omitempty"`
+	Condition    string              `json:"condition,omitempty"`
+	Notification *fcmV1Notification  `json:"notification,omitempty"`
+	Data         map[string]string   `json:"data,omitempty"`
+	Android      *fcmV1AndroidConfig `json:"android,omitempty"`
+	APNs         *fcmV1APNsConfig    `json:"apns,omitempty"`
+}
+
+type fcmV1Notification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmV1AndroidConfig struct {
+	CollapseKey  string                    `json:"collapse_key,omitempty"`
+	Priority     string                    `json:"priority,omitempty"`
+	TTL          string                    `json:"ttl,omitempty"`
+	Notification *fcmV1AndroidNotification `json:"notification,omitempty"`
+}
+
+type fcmV1AndroidNotification struct {
+	Icon                 string   `json:"icon,omitempty"`
+	Color                string   `json:"color,omitempty"`
+	Sound                string   `json:"sound,omitempty"`
+	ClickAction          string   `json:"click_action,omitempty"`
+	ChannelID            string   `json:"channel_id,omitempty"`
+	Image                string   `json:"image,omitempty"`
+	NotificationPriority string   `json:"notification_priority,omitempty"`
+	TitleLocKey          string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs         []string `json:"title_loc_args,omitempty"`
+	BodyLocKey           string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs          []string `json:"body_loc_args,omitempty"`
+}
+
+type fcmV1APNsConfig struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload,omitempty"`
+}
+
+type fcmV1Response struct {
+	Name  string      `json:"name"`
+	Error *fcmV1Error `json:"error"`
+}
+
+type fcmV1Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Details []struct {
+		Type      string `json:"@type"`
+		ErrorCode string `json:"errorCode"`
+	} `json:"details"`
+}
+
+// reason return FCM error code if present, otherwise the canonical status
+func (e *fcmV1Error) reason() string {
+	for _, detail := range e.Details {
+		if detail.ErrorCode != "" {
+			return detail.ErrorCode
+		}
+	}
+	return e.Status
+}
+
+func (c *FCMV1Client) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	base, err := fcmV1BaseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.IsTopicMessage() {
+		if msg.Condition != "" {
+			base.Condition = msg.Condition
+		} else {
+			base.Topic = msg.topicName()
+		}
+		return &DeliveryReport{Results: []TokenResult{c.sendOne(ctx, msg.topicTarget(), base)}}, nil
+	}
+	return sendBatches(ctx, PLATFORM_FCM, msg, FCMBatchSize, c.BatchConcurrency, func(ctx context.Context, batch *Message) (*DeliveryReport, error) {
+		return c.sendBatch(ctx, batch, base), nil
+	})
+}
+
+// fcmV1BaseMessage build message shared by every token of msg
+func fcmV1BaseMessage(msg *Message) (fcmV1Message, error) {
+	opts := msg.androidOptions()
+	priority := opts.Priority
+	if priority == "" {
+		priority = "high"
+	}
+	apnsOpts := msg.apnsOptions()
+	msgData, err := encodePayloadData(PLATFORM_FCM, msg.PayloadData)
+	if err != nil {
+		return fcmV1Message{}, err
+	}
+	base := fcmV1Message{
+		Data: fcmV1Data(msgData),
+		Android: &fcmV1AndroidConfig{
+			CollapseKey: opts.CollapseKey,
+			Priority:    priority,
+			Notification: &fcmV1AndroidNotification{
+				Icon:                 opts.Icon,
+				Color:                opts.Color,
+				Sound:                msg.Sound,
+				ClickAction:          opts.ClickAction,
+				ChannelID:            opts.ChannelID,
+				Image:                opts.Image,
+				NotificationPriority: opts.NotificationPriority,
+				TitleLocKey:          apnsOpts.TitleLocKey,
+				TitleLocArgs:         apnsOpts.TitleLocArgs,
+				BodyLocKey:           apnsOpts.BodyLocKey,
+				BodyLocArgs:          apnsOpts.BodyLocArgs,
+			},
+		},
+	}
+	if msg.Title != "" || msg.Body != "" {
+		base.Notification = &fcmV1Notification{
+			Title: msg.Title,
+			Body:  msg.Body,
+		}
+	}
+	if opts.TTL > 0 {
+		base.Android.TTL = fcmDuration(opts.TTL)
+	}
+	if reflect.DeepEqual(*base.Android.Notification, fcmV1AndroidNotification{}) {
+		base.Android.Notification = nil
+	}
+	// FCM limit apply to notification and data
+	encoded, err := json.Marshal(fcmV1Message{Notification: base.Notification, Data: base.Data})
+	if err != nil {
+		return base, err
+	}
+	if err := checkPayloadSize(PLATFORM_FCM, encoded, FCMMaxPayloadSize); err != nil {
+		return base, err
+	}
+
+	// iOS devices registered on FCM, category is needed by topic messages
+	// too
+	if msg.APNs != nil || msg.Badge != "" || msg.apnsCategory() != "" {
+		aps, err := json.Marshal(apnsAps(msg))
+		if err != nil {
+			return base, err
+		}
+		base.APNs = &fcmV1APNsConfig{
+			Headers: apnsHeaders(msg),
+			Payload: aps,
+		}
+	}
+	return base, nil
+}
+
+// sendBatch send base message to every token of batch, results keep token order
+func (c *FCMV1Client) sendBatch(ctx context.Context, batch *Message, base fcmV1Message) *DeliveryReport {
+	results := sendEach(batch.Tokens, cap(c.limiter), func(token string) TokenResult {
+		message := base
+		message.Token = token
+		return c.sendOne(ctx, token, message)
+	})
+	return &DeliveryReport{Results: results}
+}
+
+func (c *FCMV1Client) sendOne(ctx context.Context, token string, message fcmV1Message) TokenResult {
+	result := TokenResult{
+		Token:    token,
+		Platform: PLATFORM_FCM,
+	}
+	if err := c.limiter.acquire(ctx); err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+		return result
+	}
+	defer c.limiter.release()
+	body, err := json.Marshal(fcmV1Request{Message: message})
+	if err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+		return result
+	}
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", c.BaseURL, c.ProjectID)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+		return result
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// network error or access token could not be minted
+		result.Status = StatusFailed
+		result.Retryable = ctx.Err() == nil
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+
+	fcmResp := fcmV1Response{}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	_ = json.Unmarshal(respBody, &fcmResp)
+	if resp.StatusCode == http.StatusOK {
+		result.Status = StatusSent
+		result.MessageID = fcmResp.Name
+		return result
+	}
+
+	if fcmResp.Error != nil {
+		result.Reason = fcmResp.Error.reason()
+		result.Err = errors.New(fcmResp.Error.Message)
+	} else {
+		result.Reason = http.StatusText(resp.StatusCode)
+	}
+	switch {
+	case fcmV1InvalidTokenReasons[result.Reason]:
+		result.Status = StatusInvalidToken
+	default:
+		result.Status = StatusFailed
+		result.Retryable = fcmV1RetryableReasons[result.Reason] ||
+			resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		result.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return result
+}
+
+// fcmV1Data convert payload to data map, FCM v1 only accept string values
+func fcmV1Data(msgData map[string]interface{}) map[string]string {
+	if len(msgData) == 0 {
+		return nil
+	}
+	data := make(map[string]string, len(msgData))
+	for k, v := range msgData {
+		switch value := v.(type) {
+		case string:
+			data[k] = value
+		default:
+			raw, _ := json.Marshal(value)
+			data[k] = string(raw)
+		}
+	}
+	return data
+}
+
+// parseRetryAfter support both delay-seconds and http-date format
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func testServiceAccount(t *testing.T, tokenURL string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "key-id",
+		"private_key":    string(keyPem),
+		"client_email":   "fcm@test-project.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	return string(data)
+}
+
+func TestFCMV1Client_Send(t *testing.T) {
+	var tokenCalls int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCalls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	fcmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/test-project/messages:send" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer access-token" {
+			t.Errorf("unexpected Authorization header %q", got)
+		}
+		req := fcmV1Request{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Message.Token == "dead-token" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND",
+				"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+			return
+		}
+		if req.Message.Data["order_id"] != "10" {
+			t.Errorf("unexpected data %v", req.Message.Data)
+		}
+		_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+	}))
+	defer fcmServer.Close()
+
+	client, err := NewFCMV1Client(&FCMConfig{
+		ServiceAccountData: testServiceAccount(t, tokenServer.URL),
+		BaseURL:            fcmServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewFCMV1Client() error = %v", err)
+	}
+	report, err := client.Send(context.Background(), &Message{
+		Title:       "title",
+		Body:        "body",
+		PayloadData: map[string]interface{}{"order_id": 10},
+		Tokens:      []string{"good-token", "dead-token", "good-token-2"},
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	want := []DeliveryStatus{StatusSent, StatusInvalidToken, StatusSent}
+	for i, res := range report.Results {
+		if res.Status != want[i] {
+			t.Errorf("Results[%d].Status = %v, want %v", i, res.Status, want[i])
+		}
+	}
+	if report.Results[0].MessageID != "projects/test-project/messages/1" {
+		t.Errorf("MessageID = %v", report.Results[0].MessageID)
+	}
+	if report.Results[1].Reason != "UNREGISTERED" {
+		t.Errorf("Reason = %v, want UNREGISTERED", report.Results[1].Reason)
+	}
+	if calls := atomic.LoadInt32(&tokenCalls); calls != 1 {
+		t.Errorf("access token minted %d times, want 1", calls)
+	}
+}
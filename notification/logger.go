@@ -0,0 +1,114 @@
+package notification
+
+import (
+	"strconv"
+	Sync "sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receive logs of the package, args are key/value pairs like
+// log/slog so *slog.Logger can be used directly. It has the methods of
+// common.Logger so the same logger can be given to both packages.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+var (
+	loggerMutex Sync.RWMutex
+	// logrus standard logger until SetLogger is called
+	packageLogger Logger = LogrusLogger(logrus.StandardLogger())
+)
+
+// SetLogger replace logger of the package, it is used by AppNotification
+// created without WithLogger and by helpers without AppNotification
+// (DeviceRegistry, deprecated init functions...). nil discard logs.
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	packageLogger = logger
+}
+
+func getLogger() Logger {
+	loggerMutex.RLock()
+	defer loggerMutex.RUnlock()
+	return packageLogger
+}
+
+// WithLogger make AppNotification and its outboxes log to logger instead
+// of the package logger, nil discard logs
+func WithLogger(logger Logger) Option {
+	return func(a *AppNotification) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		a.logger = logger
+	}
+}
+
+func (a *AppNotification) log() Logger {
+	if a.logger != nil {
+		return a.logger
+	}
+	return getLogger()
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// LogrusLogger adapt logrus logger to Logger, key/value pairs become fields
+func LogrusLogger(logger logrus.FieldLogger) Logger {
+	return logrusLogger{logger: logger}
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+func (l logrusLogger) Debug(msg string, args ...interface{}) {
+	l.logger.WithFields(logFields(args)).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, args ...interface{}) {
+	l.logger.WithFields(logFields(args)).Info(msg)
+}
+
+func (l logrusLogger) Warn(msg string, args ...interface{}) {
+	l.logger.WithFields(logFields(args)).Warn(msg)
+}
+
+func (l logrusLogger) Error(msg string, args ...interface{}) {
+	l.logger.WithFields(logFields(args)).Error(msg)
+}
+
+// logFields convert key/value pairs to fields, a value without key is kept
+// under !BADKEY like slog, then !BADKEY1, !BADKEY2... so none is lost
+func logFields(args []interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+	bad := 0
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok || i+1 == len(args) {
+			badKey := "!BADKEY"
+			if bad > 0 {
+				badKey += strconv.Itoa(bad)
+			}
+			bad++
+			fields[badKey] = args[i]
+			i--
+			continue
+		}
+		fields[key] = args[i+1]
+	}
+	return fields
+}
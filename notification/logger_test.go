@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(out, nil))
+	fake := NewFakeBackend()
+	a, _ := NewAppNotification(Config{AndroidConfig: &FCMConfig{ID: "shop"}}, WithFakeBackend(fake), WithLogger(logger))
+
+	a.SendMessageCtx(context.Background(), PLATFORM_FCM, FCMClientKey("shop"), &Message{Tokens: []string{"a", "b"}})
+	for _, field := range []string{`msg="notification: send message"`, "platform=fcm", "client=FCM_shop", "tokens=2", "sent=2"} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("log missing %s in\n%s", field, out.String())
+		}
+	}
+
+	out.Reset()
+	a, _ = NewAppNotification(Config{AndroidConfig: &FCMConfig{ID: "shop"}}, WithFakeBackend(fake), WithLogger(nil))
+	a.SendMessageCtx(context.Background(), PLATFORM_FCM, FCMClientKey("shop"), &Message{Tokens: []string{"a"}})
+	if out.Len() != 0 {
+		t.Errorf("discarded logger wrote %s", out.String())
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	fields := logFields([]interface{}{"client", "FCM_shop", 42, "tokens", 3, 7, "dangling"})
+	if len(fields) != 5 || fields["client"] != "FCM_shop" || fields["tokens"] != 3 {
+		t.Errorf("logFields() = %v", fields)
+	}
+	// every value without key is kept
+	if fields["!BADKEY"] != 42 || fields["!BADKEY1"] != 7 || fields["!BADKEY2"] != "dangling" {
+		t.Errorf("logFields() bad keys = %v", fields)
+	}
+}
@@ -0,0 +1,174 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+//=================================================================
+// Instrumentation
+// - Metrics receive counters and histograms of every provider call
+// - Tracer open a span around every provider call (OpenTelemetry...)
+// - notification/prometheus collect metrics with the Prometheus client
+//=================================================================
+
+// metric names emitted by AppNotification
+const (
+	// counter of token results, labels platform, client, status, reason
+	MetricMessages = "notification_messages_total"
+	// histogram of provider call latency in seconds, labels platform, client
+	MetricSendDuration = "notification_send_duration_seconds"
+	// histogram of tokens per provider call, labels platform, client
+	MetricBatchSize = "notification_batch_size"
+)
+
+// Labels of a metric sample, label names are the same for every sample of a
+// metric
+type Labels map[string]string
+
+// Metrics receive samples, implementations must be safe for concurrent use
+type Metrics interface {
+	AddCounter(name string, labels Labels, value float64)
+	ObserveHistogram(name string, labels Labels, value float64)
+}
+
+// Tracer start a span around every provider call, ctx passed to the sender
+// is the returned one so provider HTTP calls can be traced as children
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attributes map[string]interface{})
+	// End finish span, err is the provider call error if any
+	End(err error)
+}
+
+// SetMetrics register metrics receiver, it should be set before sending
+func (a *AppNotification) SetMetrics(metrics Metrics) {
+	a.metrics = metrics
+}
+
+// SetTracer register tracer, it should be set before sending
+func (a *AppNotification) SetTracer(tracer Tracer) {
+	a.tracer = tracer
+}
+
+func platformName(platform int) string {
+	switch platform {
+	case PLATFORM_APNs:
+		return "apns"
+	case PLATFORM_FCM:
+		return "fcm"
+	case PLATFORM_WebPush:
+		return "webpush"
+	case PLATFORM_Email:
+		return "email"
+	case PLATFORM_SMS:
+		return "sms"
+	}
+	return "unknown"
+}
+
+// sendInstrumented call sender of client inside a span and record latency
+// and batch size
+func (a *AppNotification) sendInstrumented(ctx context.Context, clientKey string, client *Client, msg *Message) (*DeliveryReport, error) {
+	platform := platformName(client.Platform)
+	batchSize := len(msg.Tokens)
+	if msg.IsTopicMessage() {
+		batchSize = 1
+	}
+	var span Span
+	if a.tracer != nil {
+		ctx, span = a.tracer.StartSpan(ctx, "notification.send", map[string]interface{}{
+			"notification.platform": platform,
+			"notification.client":   clientKey,
+			"notification.tokens":   batchSize,
+		})
+	}
+	start := time.Now()
+	report, err := client.sender.Send(ctx, msg)
+	duration := time.Since(start)
+	if span != nil {
+		if report != nil {
+			span.SetAttributes(map[string]interface{}{
+				"notification.sent":   report.SuccessCount(),
+				"notification.failed": report.FailureCount(),
+			})
+		}
+		span.End(err)
+	}
+	if a.metrics != nil {
+		labels := Labels{"platform": platform, "client": clientKey}
+		a.metrics.ObserveHistogram(MetricSendDuration, labels, duration.Seconds())
+		a.metrics.ObserveHistogram(MetricBatchSize, labels, float64(batchSize))
+	}
+	return report, err
+}
+
+// countResults add every token result of report to MetricMessages
+func (a *AppNotification) countResults(clientKey string, report *DeliveryReport) {
+	if a.metrics == nil {
+		return
+	}
+	for _, res := range report.Results {
+		a.metrics.AddCounter(MetricMessages, Labels{
+			"platform": platformName(res.Platform),
+			"client":   clientKey,
+			"status":   string(res.Status),
+			"reason":   reasonLabel(res.Reason),
+		}, 1)
+	}
+}
+
+// metricReasons are the reasons kept as reason label, email and SMS gateways
+// return free text which may contain addresses and would make series
+// unbounded, so any other reason is counted as "other"
+var metricReasons = newMetricReasons(
+	// APNs
+	"BadCollapseId", "BadDeviceToken", "BadExpirationDate", "BadMessageId",
+	"BadPriority", "BadTopic", "DeviceTokenNotForTopic", "DuplicateHeaders",
+	"IdleTimeout", "InvalidPushType", "MissingDeviceToken", "MissingTopic",
+	"PayloadEmpty", "TopicDisallowed", "BadCertificate", "BadCertificateEnvironment",
+	"ExpiredProviderToken", "Forbidden", "InvalidProviderToken", "MissingProviderToken",
+	"BadPath", "MethodNotAllowed", "ExpiredToken", "Unregistered", "PayloadTooLarge",
+	"TooManyProviderTokenUpdates", "TooManyRequests", "InternalServerError",
+	"ServiceUnavailable", "Shutdown",
+	// FCM legacy
+	"MissingRegistration", "InvalidRegistration", "NotRegistered", "InvalidPackageName",
+	"MismatchSenderId", "InvalidParameters", "MessageTooBig", "InvalidDataKey",
+	"InvalidTtl", "Unavailable", "DeviceMessageRateExceeded", "TopicsMessageRateExceeded",
+	"InvalidApnsCredential",
+	// FCM v1 and Instance ID
+	"UNSPECIFIED_ERROR", "INVALID_ARGUMENT", "UNREGISTERED", "SENDER_ID_MISMATCH",
+	"QUOTA_EXCEEDED", "UNAVAILABLE", "INTERNAL", "THIRD_PARTY_AUTH_ERROR",
+	"NOT_FOUND", "PERMISSION_DENIED", "UNAUTHENTICATED", "RESOURCE_EXHAUSTED",
+	// email, SMS and web push
+	"InvalidAddress", "InvalidPhoneNumber", "InvalidTemplate",
+	"InvalidSubscription", "InvalidSubscriptionKeys", "InvalidEndpoint",
+	// send policy
+	SkipRateLimited, SkipQuietHours, SkipDuplicate,
+)
+
+// newMetricReasons return set of reasons and HTTP status texts
+func newMetricReasons(reasons ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, reason := range reasons {
+		set[reason] = true
+	}
+	for code := 100; code < 600; code++ {
+		if text := http.StatusText(code); text != "" {
+			set[text] = true
+		}
+	}
+	return set
+}
+
+// reasonLabel return reason if it is a known code, "other" otherwise
+func reasonLabel(reason string) string {
+	if reason == "" || metricReasons[reason] {
+		return reason
+	}
+	return "other"
+}
@@ -0,0 +1,120 @@
+package notification
+
+import (
+	"context"
+	"sort"
+	Sync "sync"
+	"testing"
+)
+
+type recordTracer struct {
+	spans []*recordSpan
+}
+
+type recordSpan struct {
+	name       string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (t *recordTracer) StartSpan(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span) {
+	span := &recordSpan{name: name, attributes: attributes}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordSpan) SetAttributes(attributes map[string]interface{}) {
+	for key, value := range attributes {
+		s.attributes[key] = value
+	}
+}
+
+func (s *recordSpan) End(err error) {
+	s.ended = true
+}
+
+// recordMetrics keep counters and the number of histogram samples by metric
+// name and labels
+type recordMetrics struct {
+	mutex      Sync.Mutex
+	counters   map[string]float64
+	histograms map[string]int
+}
+
+func newRecordMetrics() *recordMetrics {
+	return &recordMetrics{counters: map[string]float64{}, histograms: map[string]int{}}
+}
+
+func metricKey(name string, labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	key := name
+	for _, label := range names {
+		key += " " + label + "=" + labels[label]
+	}
+	return key
+}
+
+func (m *recordMetrics) AddCounter(name string, labels Labels, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.counters[metricKey(name, labels)] += value
+}
+
+func (m *recordMetrics) ObserveHistogram(name string, labels Labels, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.histograms[metricKey(name, labels)]++
+}
+
+func TestAppNotification_Metrics(t *testing.T) {
+	fake := NewFakeBackend()
+	a, _ := NewAppNotification(Config{AndroidConfig: &FCMConfig{ID: "shop"}}, WithFakeBackend(fake))
+	metrics := newRecordMetrics()
+	tracer := &recordTracer{}
+	a.SetMetrics(metrics)
+	a.SetTracer(tracer)
+
+	fake.SetInvalidToken("old")
+	a.SendMessageCtx(context.Background(), PLATFORM_FCM, FCMClientKey("shop"), &Message{Tokens: []string{"a", "b", "old"}})
+
+	for key, want := range map[string]float64{
+		MetricMessages + " client=FCM_shop platform=fcm reason= status=sent":                      2,
+		MetricMessages + " client=FCM_shop platform=fcm reason=Unregistered status=invalid_token": 1,
+	} {
+		if metrics.counters[key] != want {
+			t.Errorf("counter %q = %v, want %v in %v", key, metrics.counters[key], want, metrics.counters)
+		}
+	}
+	for _, name := range []string{MetricSendDuration, MetricBatchSize} {
+		if key := metricKey(name, Labels{"client": "FCM_shop", "platform": "fcm"}); metrics.histograms[key] != 1 {
+			t.Errorf("histogram %q has %d samples, want 1", key, metrics.histograms[key])
+		}
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("%d spans, want 1", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "notification.send" || !span.ended || span.attributes["notification.tokens"] != 3 || span.attributes["notification.sent"] != 2 {
+		t.Errorf("span = %+v", span)
+	}
+}
+
+func TestReasonLabel(t *testing.T) {
+	for reason, want := range map[string]string{
+		"":                                     "",
+		"Unregistered":                         "Unregistered",
+		"UNREGISTERED":                         "UNREGISTERED",
+		"Service Unavailable":                  "Service Unavailable",
+		SkipQuietHours:                         SkipQuietHours,
+		"5.1.1 <bob@example.com> user unknown": "other",
+	} {
+		if got := reasonLabel(reason); got != want {
+			t.Errorf("reasonLabel(%q) = %q, want %q", reason, got, want)
+		}
+	}
+}
@@ -1,48 +1,115 @@
 package notification
 
 import (
-	"crypto/ecdsa"
-	"crypto/tls"
-	"github.com/sirupsen/logrus"
-	"log"
+	"context"
+	"errors"
+	"strings"
 	Sync "sync"
-
-	// android - FCM
-	"github.com/NaySoftware/go-fcm"
-	"github.com/sideshow/apns2"
-	"github.com/sideshow/apns2/certificate"
-	"github.com/sideshow/apns2/payload"
-	"github.com/sideshow/apns2/token"
 )
 
 const (
-	PLATFORM_APNs = 1
-	PLATFORM_FCM  = 2
+	PLATFORM_APNs    = 1
+	PLATFORM_FCM     = 2
+	PLATFORM_WebPush = 3
+	PLATFORM_Email   = 4
+	PLATFORM_SMS     = 5
+)
+
+var (
+	ErrClientNotFound       = errors.New("notification: client not found")
+	ErrClientNotInitialized = errors.New("notification: client was not initialized")
+	// async send started after Drain, tokens are failed as retryable
+	ErrDraining = errors.New("notification: app notification is draining")
 )
 
 type AppNotification struct {
-	// list of client
+	// list of client, guarded by mutex so clients can be changed at runtime
+	mutex   Sync.RWMutex
 	clients map[string]*Client
 	// config object loaded from file
 	config Config
+	// called for every token which provider reported as invalid
+	invalidator TokenInvalidator
+	// async sends which are not finished yet, see Drain. draining is set
+	// by Drain under drainMutex so no send is added while it wait.
+	drainMutex Sync.Mutex
+	draining   bool
+	inFlight   Sync.WaitGroup
+	// message templates used by RenderAndSend
+	templates *TemplateRegistry
+	// rate limit, quiet hours and dedup, nil when Config.Policy is not set
+	policy        *sendPolicy
+	ownerResolver TokenOwnerResolver
+	deferOutbox   *Outbox
+	// registered devices used by SendToUsers
+	devices *DeviceRegistry
+	// create senders of config clients instead of provider clients, see
+	// WithSenderFactory
+	newSender SenderFactory
+	// instrumentation, nil when not set
+	metrics Metrics
+	tracer  Tracer
+	// nil use the package logger, see WithLogger
+	logger Logger
+}
+
+// TokenInvalidator is notified when provider report a token will never work
+// again (APNs BadDeviceToken/Unregistered/ExpiredToken, FCM NotRegistered/
+// UNREGISTERED), apps usually delete the token from their storage. When a
+// message is sent to many clients a token is only invalidated if no client
+// delivered it.
+// It may be called concurrently by sends running in parallel.
+type TokenInvalidator interface {
+	InvalidateToken(token string, platform int, reason string)
+}
+
+// TokenInvalidatorFunc allow using a plain func as TokenInvalidator
+type TokenInvalidatorFunc func(token string, platform int, reason string)
+
+func (f TokenInvalidatorFunc) InvalidateToken(token string, platform int, reason string) {
+	f(token, platform, reason)
+}
+
+// AppTokenInvalidator is a TokenInvalidator which is also told the app of
+// the client reporting the token, it is used instead of InvalidateToken so
+// a token registered for another app of the platform can be kept, it may be
+// called concurrently too
+type AppTokenInvalidator interface {
+	InvalidateAppToken(token string, platform int, appID string, reason string)
 }
 
 type Client struct {
 	Platform int
-	Mutex    *Sync.Mutex
+	// ID of app config and tenant owning the app, used for routing
+	AppID  string
+	Tenant string
 	// android
 	SenderID string
 
 	// iOS
 	AppBundleID string
 
-	androidClient *fcm.FcmClient
-	iOSClient     *apns2.Client
+	sender Sender
+	// nil when provider does not support topic subscription
+	topics TopicManager
 }
 
 type Config struct {
-	AndroidConfig *FCMConfig  `json:"android"`
-	IOSConfig     *APNsConfig `json:"ios"`
+	AndroidConfig *FCMConfig     `json:"android"`
+	IOSConfig     *APNsConfig    `json:"ios"`
+	WebConfig     *WebPushConfig `json:"web"`
+	EmailConfig   *EmailConfig   `json:"email"`
+	SMSConfig     *SMSConfig     `json:"sms"`
+	// more apps (white-label), IDs must be unique per platform
+	AndroidConfigs []*FCMConfig     `json:"android_apps"`
+	IOSConfigs     []*APNsConfig    `json:"ios_apps"`
+	WebConfigs     []*WebPushConfig `json:"web_apps"`
+	EmailConfigs   []*EmailConfig   `json:"email_apps"`
+	SMSConfigs     []*SMSConfig     `json:"sms_apps"`
+	// retry transient failures, nil disable retry
+	Retry *RetryPolicy `json:"retry"`
+	// rate limit, quiet hours and dedup, nil disable them
+	Policy *PolicyConfig `json:"policy"`
 }
 
 type Message struct {
@@ -50,269 +117,325 @@ type Message struct {
 	Body        string
 	PayloadData interface{}
 	Tokens      []string
-	Topic       string
-	Sound       string
-	Badge       string
-}
-
-func NewNotificationHelper(config Config) *AppNotification {
-	android, iOS := &Client{}, &Client{}
-	if config.AndroidConfig != nil {
-		androidClient := FCMInitFromConfig(config.AndroidConfig)
-		if androidClient != nil {
-			android = &Client{
-				Platform:      PLATFORM_FCM,
-				Mutex:         &Sync.Mutex{},
-				androidClient: androidClient,
-				SenderID:      config.AndroidConfig.ClientID,
-			}
-		}
+	// push tokens, emails and phone numbers, see SendToRecipients
+	Recipients []Recipient
+	// FCM topic ("news" or "/topics/news") or condition expression
+	// ("'a' in topics && 'b' in topics"), only used when Tokens is empty.
+	// For token sends Topic is still used as APNs category when Category is
+	// empty to keep old behavior, topic messages only use Category.
+	Topic     string
+	Condition string
+	// APNs category
+	Category string
+	Sound    string
+	Badge    string
+	// platform specific overrides, nil use defaults
+	APNs    *APNsOptions
+	Android *AndroidOptions
+	Web     *WebPushOptions
+	Email   *EmailOptions
+	// owner of Tokens and its IANA timezone, used by send policies when
+	// recipient or TokenOwnerResolver does not tell them
+	UserID   string
+	Timezone string
+	// send even in quiet hours, ex: security alerts
+	IgnoreQuietHours bool
+
+	// owner of tokens which came from Recipients
+	owners map[string]tokenOwner
+}
+
+// IsTopicMessage return true when msg target a FCM topic or condition
+// instead of device tokens
+func (m *Message) IsTopicMessage() bool {
+	return len(m.Tokens) == 0 && (m.Topic != "" || m.Condition != "")
+}
+
+// topicName return topic without /topics/ prefix
+func (m *Message) topicName() string {
+	return strings.TrimPrefix(m.Topic, "/topics/")
+}
+
+// topicTarget is used as TokenResult.Token of topic message
+func (m *Message) topicTarget() string {
+	if m.Condition != "" {
+		return m.Condition
 	}
-	if config.IOSConfig != nil {
-		iOSClient := APNsInitFromConfig(config.IOSConfig)
-		if iOSClient != nil {
-			iOS = &Client{
-				Platform:    PLATFORM_APNs,
-				Mutex:       &Sync.Mutex{},
-				iOSClient:   iOSClient,
-				AppBundleID: config.IOSConfig.AppBundleID,
-			}
-		}
+	return "/topics/" + m.topicName()
+}
+
+// apnsCategory return Category, fall back to Topic for old callers which
+// send to tokens
+func (m *Message) apnsCategory() string {
+	if m.Category != "" || m.IsTopicMessage() {
+		return m.Category
 	}
-	return &AppNotification{
-		clients: map[string]*Client{
-			"FCM_" + config.AndroidConfig.ID: android,
-			"APNs_" + config.IOSConfig.ID:    iOS,
-		},
+	return m.Topic
+}
+
+// Option customize AppNotification created by constructors
+type Option func(a *AppNotification)
+
+// SenderFactory create sender of client of platform and app config ID
+type SenderFactory func(platform int, appID string) Sender
+
+// WithSenderFactory make clients of config send through senders created by
+// factory instead of FCM / APNs... providers, config is not validated so
+// credentials are not needed. It is also used by ReloadConfig.
+func WithSenderFactory(factory SenderFactory) Option {
+	return func(a *AppNotification) {
+		a.newSender = factory
 	}
 }
 
-func NewNotificationHelperFCM(config Config) *AppNotification {
-	android := &Client{}
-	if config.AndroidConfig != nil {
-		androidClient := FCMInitFromConfig(config.AndroidConfig)
-		if androidClient != nil {
-			android = &Client{
-				Platform:      PLATFORM_FCM,
-				Mutex:         &Sync.Mutex{},
-				androidClient: androidClient,
-				SenderID:      config.AndroidConfig.ClientID,
-			}
-		}
-		return &AppNotification{
-			clients: map[string]*Client{
-				"FCM_" + config.AndroidConfig.ID: android,
-			},
-		}
+// WithFakeBackend make every client of config record to fake instead of
+// sending, for tests
+func WithFakeBackend(fake *FakeBackend) Option {
+	return WithSenderFactory(fake.Sender)
+}
+
+// NewAppNotification validate config and create every client of it, no
+// client is created if config has any problem
+func NewAppNotification(config Config, options ...Option) (*AppNotification, error) {
+	a := &AppNotification{
+		config: config,
+		policy: newSendPolicy(config.Policy),
+	}
+	for _, option := range options {
+		option(a)
+	}
+	clients, err := buildClients(config, a.newSender)
+	if err != nil {
+		return nil, err
 	}
-	return &AppNotification{
+	a.clients = clients
+	return a, nil
+}
+
+// NewAppNotificationFCM is NewAppNotification with android configs only
+func NewAppNotificationFCM(config Config, options ...Option) (*AppNotification, error) {
+	config.IOSConfig = nil
+	config.IOSConfigs = nil
+	config.WebConfig = nil
+	config.WebConfigs = nil
+	config.EmailConfig = nil
+	config.EmailConfigs = nil
+	config.SMSConfig = nil
+	config.SMSConfigs = nil
+	return NewAppNotification(config, options...)
+}
+
+// NewNotificationHelper create clients of every android and iOS config,
+// a client which could not be initialized is kept empty and fail on send.
+// Deprecated: use NewAppNotification to get config errors.
+func NewNotificationHelper(config Config, options ...Option) *AppNotification {
+	a := &AppNotification{
 		clients: map[string]*Client{},
+		config:  config,
+		policy:  newSendPolicy(config.Policy),
+	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.newSender != nil {
+		a.clients = buildFactoryClients(config, a.newSender)
+		return a
+	}
+	for _, fcmConfig := range config.fcmConfigs() {
+		a.clients[FCMClientKey(fcmConfig.ID)] = clientOrEmpty(NewFCMClient(fcmConfig, config.Retry))
 	}
+	for _, apnsConfig := range config.apnsConfigs() {
+		a.clients[APNsClientKey(apnsConfig.ID)] = clientOrEmpty(NewAPNsClient(apnsConfig, config.Retry))
+	}
+	for _, webConfig := range config.webConfigs() {
+		a.clients[WebPushClientKey(webConfig.ID)] = clientOrEmpty(NewWebPushClient(webConfig, config.Retry))
+	}
+	for _, emailConfig := range config.emailConfigs() {
+		a.clients[EmailClientKey(emailConfig.ID)] = clientOrEmpty(NewEmailClient(emailConfig, config.Retry))
+	}
+	for _, smsConfig := range config.smsConfigs() {
+		a.clients[SMSClientKey(smsConfig.ID)] = clientOrEmpty(NewSMSClient(smsConfig, config.Retry))
+	}
+	return a
 }
 
-func (a *AppNotification) SendMessageForAll(msg *Message) {
+// NewNotificationHelperFCM is NewNotificationHelper with android configs only.
+// Deprecated: use NewAppNotificationFCM to get config errors.
+func NewNotificationHelperFCM(config Config, options ...Option) *AppNotification {
+	config.IOSConfig = nil
+	config.IOSConfigs = nil
+	config.WebConfig = nil
+	config.WebConfigs = nil
+	config.EmailConfig = nil
+	config.EmailConfigs = nil
+	config.SMSConfig = nil
+	config.SMSConfigs = nil
+	return NewNotificationHelper(config, options...)
+}
+
+// SetTokenInvalidator register invalidator, it should be set before sending
+func (a *AppNotification) SetTokenInvalidator(invalidator TokenInvalidator) {
+	a.invalidator = invalidator
+}
+
+// SendMessageForAll send msg to every push client and wait for all of them,
+// the returned report contains results of all clients. Email and SMS are
+// only sent with SendToRecipients.
+func (a *AppNotification) SendMessageForAll(msg *Message) *DeliveryReport {
+	return a.SendMessageForAllCtx(context.Background(), msg)
+}
+
+func (a *AppNotification) SendMessageForAndroid(msg *Message) *DeliveryReport {
+	return a.SendMessageForAndroidCtx(context.Background(), msg)
+}
+
+func (a *AppNotification) SendMessageForIOS(msg *Message) *DeliveryReport {
+	return a.SendMessageForIOSCtx(context.Background(), msg)
+}
+
+func (a *AppNotification) SendMessageForWeb(msg *Message) *DeliveryReport {
+	return a.SendMessageForWebCtx(context.Background(), msg)
+}
+
+func (a *AppNotification) SendMessage(platform int, clientID string, msg *Message) *DeliveryReport {
+	return a.SendMessageCtx(context.Background(), platform, clientID, msg)
+}
+
+// SendMessageForAllCtx is SendMessageForAll with ctx, cancel ctx or its
+// deadline abort in-flight provider calls
+func (a *AppNotification) SendMessageForAllCtx(ctx context.Context, msg *Message) *DeliveryReport {
 	msg.Sound = "default"
-	for key, client := range a.clients {
-		log.Printf("SendMessageForAll key ", key)
-		go a.SendMessage(client.Platform, key, msg)
-	}
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return isPushPlatform(client.Platform)
+	})
 }
 
-func (a *AppNotification) SendMessageForAndroid(msg *Message) {
-	for key, client := range a.clients {
-		if client.Platform == PLATFORM_FCM {
-			go a.SendMessage(client.Platform, key, msg)
-		}
-	}
+func (a *AppNotification) SendMessageForAndroidCtx(ctx context.Context, msg *Message) *DeliveryReport {
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return client.Platform == PLATFORM_FCM
+	})
 }
 
-func (a *AppNotification) SendMessageForIOS(msg *Message) {
-	for key, client := range a.clients {
-		if client.Platform == PLATFORM_APNs {
-			go a.SendMessage(client.Platform, key, msg)
-		}
-	}
+func (a *AppNotification) SendMessageForIOSCtx(ctx context.Context, msg *Message) *DeliveryReport {
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return client.Platform == PLATFORM_APNs
+	})
 }
 
-func (a *AppNotification) SendMessage(platform int, clientID string, msg *Message) {
-	if client, found := a.clients[clientID]; found {
-		logrus.Infof("SendMessage client.Platform ", client.Platform)
-		logrus.Infof("SendMessage token ", msg.Tokens)
-		switch client.Platform {
-		case PLATFORM_FCM:
-			a._sendFCM(client, msg)
-			break
-		case PLATFORM_APNs:
-			a._sendAPNs(client, msg)
-			break
-		default:
-			log.Println("Unsupported platform ID platform ", platform)
-			break
+func (a *AppNotification) SendMessageForWebCtx(ctx context.Context, msg *Message) *DeliveryReport {
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return client.Platform == PLATFORM_WebPush
+	})
+}
+
+// sendMessageFor send msg concurrently to clients matched by filter and merge reports
+func (a *AppNotification) sendMessageFor(ctx context.Context, msg *Message, filter func(client *Client) bool) *DeliveryReport {
+	report := &DeliveryReport{}
+	mutex := &Sync.Mutex{}
+	wg := &Sync.WaitGroup{}
+	for key, client := range a.clientList() {
+		if !filter(client) {
+			continue
 		}
-	} else {
-		log.Println("Unsupported platform ID client ", "Client not found")
+		wg.Add(1)
+		go func(key string, client *Client) {
+			defer wg.Done()
+			// client of the snapshot, it may be removed or replaced meanwhile
+			res := a.sendWithClient(ctx, key, client, msg)
+			mutex.Lock()
+			report.merge(res)
+			mutex.Unlock()
+		}(key, client)
 	}
+	wg.Wait()
+	// tokens are invalidated once every client answered, a token of one
+	// app is rejected by the other ones
+	a.invalidateTokens(report)
+	return report
 }
 
-func (a *AppNotification) _sendAPNs(client *Client, msg *Message) {
-	client.Mutex.Lock()
-	// create payload data
-	pData := payload.NewPayload()
-	pData.AlertTitle(msg.Title)
-	pData.AlertBody(msg.Body)
-	pData.MutableContent()
-	pData.Category(msg.Topic)
-	msgData := msg.PayloadData.(map[string]interface{})
-	for k, v := range msgData {
-		pData.Custom(k, v)
-	}
-	// setup notification
-	notification := &apns2.Notification{
-		DeviceToken: "",
-		Payload:     pData,
-		Topic:       client.AppBundleID,
-	}
+func (a *AppNotification) SendMessageCtx(ctx context.Context, platform int, clientID string, msg *Message) *DeliveryReport {
+	report := a.sendMessage(ctx, platform, clientID, msg)
+	a.invalidateTokens(report)
+	return report
+}
 
-	// send
-	for _, deviceToken := range msg.Tokens {
-		notification.DeviceToken = deviceToken
-		status, err := client.iOSClient.Push(notification)
-		if err != nil {
-			logrus.Infof("send APNs error ", err.Error())
-		} else {
-			if status.Sent() {
-				logrus.Infof("send APNs error ", "Sent successfully")
-			} else {
-				logrus.Errorf("send APNs error ", "Sent failed")
-			}
-		}
+// sendMessage send msg through one client, invalid tokens are not reported
+// to the invalidator
+func (a *AppNotification) sendMessage(ctx context.Context, platform int, clientID string, msg *Message) *DeliveryReport {
+	client, found := a.Client(clientID)
+	if !found {
+		a.log().Error("notification: client not found", "platform", platformName(platform), "client", clientID)
+		return failAll(platform, msg, ErrClientNotFound, false)
 	}
-	client.Mutex.Unlock()
-}
-
-func (a *AppNotification) _sendFCM(client *Client, msg *Message) {
-	client.Mutex.Lock()
-	// prepare and send message
-	client.androidClient.SetPriority("high")
-	client.androidClient.NewFcmRegIdsMsg(msg.Tokens, msg.PayloadData)
-	client.androidClient.SetNotificationPayload(&fcm.NotificationPayload{
-		Title: msg.Title,
-		Body:  msg.Body,
-		Sound: msg.Sound,
-		Badge: msg.Badge,
-		//TODO: if support for fcm APNs then need set other field here
-	})
-	status, err := client.androidClient.Send()
-	if err == nil {
-		logrus.Infof("send FCM ", "Sent successfully")
-		logrus.Infof("Status Code", status.StatusCode)
-		logrus.Infof("Success", status.Success)
-
-	} else {
-		logrus.Errorf("send FCM error", err.Error())
+	if client.sender == nil {
+		a.log().Error("notification: client was not initialized", "platform", platformName(platform), "client", clientID)
+		return failAll(platform, msg, ErrClientNotInitialized, false)
 	}
-	client.Mutex.Unlock()
-}
-
-//=================================================================
-// Android service
-// - Support server key
-//=================================================================
-type FCMConfig struct {
-	ID        string `json:"id"`
-	ServerKey string `json:"server_key"`
-	ClientID  string `json:"client_id"`
+	return a.sendWithClient(ctx, clientID, client, msg)
 }
 
-func FCMInitFromConfig(config *FCMConfig) *fcm.FcmClient {
-	if config.ServerKey == "" {
-		log.Println("send FCMInitFromConfig error", "Error when init FCM client from config! Server key was empty please check again.")
-		return nil
+// sendWithClient send msg through client registered with clientID
+func (a *AppNotification) sendWithClient(ctx context.Context, clientID string, client *Client, msg *Message) *DeliveryReport {
+	if client.sender == nil {
+		a.log().Error("notification: client was not initialized", "platform", platformName(client.Platform), "client", clientID)
+		return failAll(client.Platform, msg, ErrClientNotInitialized, false)
 	}
-	client := fcm.NewFcmClient(config.ServerKey)
-	return client
-}
-
-//=================================================================
-// iOS service
-// - Support p12, pem, p8 key
-//=================================================================
-type APNsConfig struct {
-	ID      string `json:"id"`
-	KeyType string `json:"key_type"`
-	// path of key file
-	KeyFilePath string `json:"key_path"`
-	KeyData     string `json:"key_data"`
-	// not support for now
-	KeyBase64     string
-	KeyBase64Type string
-	// password for .p12 and .pem
-	Password string `json:"password"`
-	// ID for .p8
-	KeyID  string `json:"p8_key_id"`
-	TeamID string `json:"p8_team_id"`
-	// type
-	IsProduction bool   `json:"is_production"`
-	AppBundleID  string `json:"app_bundle_id"`
-}
-
-func APNsInitFromConfig(config *APNsConfig) *apns2.Client {
-	switch config.KeyType {
-	case "p12":
-		certificateKey, err := certificate.FromP12File(config.KeyFilePath, config.Password)
-		if err != nil {
-			log.Println("platform APNs:Error when create certificate, Please check key file is correct or not ", err.Error())
-			return nil
-		}
-		return apns_NewClient_P12_Pem(certificateKey, config)
-	case "pem":
-		certificateKey, err := certificate.FromPemFile(config.KeyFilePath, config.Password)
-		if err != nil {
-			log.Println("platform APNs:Error when create certificate, Please check key file is correct or not ", err.Error())
-			return nil
-		}
-		return apns_NewClient_P12_Pem(certificateKey, config)
-	case "p8":
+	a.log().Info("notification: send message", "platform", platformName(client.Platform), "client", clientID, "tokens", len(msg.Tokens))
+	allowedMsg, skipped := a.applyPolicy(ctx, clientID, client, msg)
+	report := &DeliveryReport{}
+	if len(allowedMsg.Tokens) > 0 || msg.IsTopicMessage() {
 		var err error
-		var authKey *ecdsa.PrivateKey
-		if config.KeyFilePath != "" {
-			authKey, err = token.AuthKeyFromFile(config.KeyFilePath)
-		} else {
-			authKey, err = token.AuthKeyFromBytes([]byte(config.KeyData))
-		}
+		report, err = a.sendInstrumented(ctx, clientID, client, allowedMsg)
 		if err != nil {
-			log.Println("platform APNs:Error when create authentication key, Please check key file is correct or not", err.Error())
-			return nil
+			a.log().Error("notification: send message failed", "platform", platformName(client.Platform), "client", clientID, "tokens", len(allowedMsg.Tokens), "error", err)
+			report = failAll(client.Platform, allowedMsg, err, false)
 		}
-		return apns_NewClient_P8(authKey, config)
-	default:
-		log.Println("platform APNs:Init APNs failed. Key was invalid. APNs only support for .p12, .pem, .p8")
-		break
+		a.forgetUnsent(clientID, allowedMsg, report)
 	}
-	return nil
+	report = withSkipped(report, skipped, len(msg.Tokens))
+	for i := range report.Results {
+		report.Results[i].ClientKey = clientID
+	}
+	a.countResults(clientID, report)
+	a.log().Info("notification: message sent", "platform", platformName(client.Platform), "client", clientID, "sent", report.SuccessCount(), "failed", report.FailureCount(), "skipped", report.SkippedCount())
+	return report
 }
 
-func apns_NewClient_P12_Pem(cer tls.Certificate, config *APNsConfig) *apns2.Client {
-	if config.IsProduction {
-		return apns2.NewClient(cer).Production()
-	} else {
-		return apns2.NewClient(cer).Development()
+// invalidateToken notify invalidator of one invalid token, with the app of
+// the reporting client when invalidator is an AppTokenInvalidator
+func (a *AppNotification) invalidateToken(res TokenResult) {
+	invalidator, ok := a.invalidator.(AppTokenInvalidator)
+	if !ok {
+		a.invalidator.InvalidateToken(res.Token, res.Platform, res.Reason)
+		return
+	}
+	appID := ""
+	if client, found := a.Client(res.ClientKey); found {
+		appID = client.AppID
 	}
+	invalidator.InvalidateAppToken(res.Token, res.Platform, appID, res.Reason)
 }
 
-func apns_NewClient_P8(authKey *ecdsa.PrivateKey, config *APNsConfig) *apns2.Client {
-	// init token
-	token := &token.Token{
-		AuthKey: authKey,
-		// KeyID from developer account (Certificates, Identifiers & Profiles -> Keys)
-		KeyID: config.KeyID,
-		// TeamID from developer account (View Account -> Membership)
-		TeamID: config.TeamID,
+// invalidateTokens notify invalidator of invalid tokens of report, a token
+// which was delivered by another client of report belongs to that client and
+// is kept
+func (a *AppNotification) invalidateTokens(report *DeliveryReport) {
+	if a.invalidator == nil {
+		return
 	}
-	// create client
-	if config.IsProduction {
-		return apns2.NewTokenClient(token).Production()
-	} else {
-		return apns2.NewTokenClient(token).Development()
+	delivered := map[string]bool{}
+	for _, res := range report.Results {
+		if res.Sent() {
+			delivered[res.Token] = true
+		}
+	}
+	notified := map[string]bool{}
+	for _, res := range report.Results {
+		if res.Status == StatusInvalidToken && !delivered[res.Token] && !notified[res.Token] {
+			notified[res.Token] = true
+			a.log().Info("notification: invalidate token", "platform", platformName(res.Platform), "client", res.ClientKey, "token", res.Token, "reason", res.Reason)
+			a.invalidateToken(res)
+		}
 	}
 }
@@ -0,0 +1,135 @@
+package notification
+
+import (
+	"context"
+	"sort"
+	Sync "sync"
+	"testing"
+
+	"github.com/sideshow/apns2"
+)
+
+func TestAppNotification_SendMessageFor(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeBackend()
+	a, err := NewAppNotification(Config{
+		AndroidConfigs: []*FCMConfig{{ID: "shop"}, {ID: "driver"}},
+		IOSConfig:      &APNsConfig{ID: "shop-ios"},
+		WebConfig:      &WebPushConfig{ID: "web"},
+	}, WithFakeBackend(fake))
+	if err != nil {
+		t.Fatalf("NewAppNotification() error = %v", err)
+	}
+	clientsOf := func(report *DeliveryReport) []string {
+		var keys []string
+		for _, res := range report.Results {
+			keys = append(keys, res.ClientKey)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	assertClients := func(name string, got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s clients = %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s clients = %v, want %v", name, got, want)
+				return
+			}
+		}
+	}
+
+	report := a.SendMessageForAndroidCtx(ctx, &Message{Tokens: []string{"a"}})
+	assertClients("android", clientsOf(report), "FCM_driver", "FCM_shop")
+	report = a.SendMessageForIOSCtx(ctx, &Message{Tokens: []string{"a"}})
+	assertClients("ios", clientsOf(report), "APNs_shop-ios")
+	report = a.SendMessageForWebCtx(ctx, &Message{Tokens: []string{"a"}})
+	assertClients("web", clientsOf(report), "WebPush_web")
+
+	// every client report every token, results of a client keep token order
+	fake.SetRateLimited("b", 0)
+	report = a.SendMessageForAllCtx(ctx, &Message{Tokens: []string{"a", "b"}})
+	if len(report.Results) != 8 || report.SuccessCount() != 4 || report.FailureCount() != 4 {
+		t.Errorf("all results = %d, SuccessCount() = %d, FailureCount() = %d", len(report.Results), report.SuccessCount(), report.FailureCount())
+	}
+	for i := 0; i < len(report.Results); i += 2 {
+		first, second := report.Results[i], report.Results[i+1]
+		if first.ClientKey != second.ClientKey || first.Token != "a" || second.Token != "b" {
+			t.Errorf("results %d-%d = %+v, %+v", i, i+1, first, second)
+		}
+		if first.Platform == 0 || first.Platform != second.Platform {
+			t.Errorf("platform of %s = %d", first.ClientKey, first.Platform)
+		}
+	}
+
+	report = a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("missing"), &Message{Tokens: []string{"a"}})
+	if len(report.Results) != 1 || report.Results[0].Err != ErrClientNotFound {
+		t.Errorf("unknown client results = %+v", report.Results)
+	}
+}
+
+// statusSender report tokens with the status of its map, other tokens are sent
+type statusSender struct {
+	platform int
+	statuses map[string]DeliveryStatus
+}
+
+func (s *statusSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	report := &DeliveryReport{}
+	for _, token := range msg.Tokens {
+		status, found := s.statuses[token]
+		if !found {
+			status = StatusSent
+		}
+		report.add(TokenResult{Token: token, Platform: s.platform, Status: status})
+	}
+	return report, nil
+}
+
+func TestAppNotification_InvalidateTokens(t *testing.T) {
+	a, _ := NewAppNotification(Config{})
+	// "shared" is a token of app2, app1 does not know it
+	_ = a.AddClient(FCMClientKey("app1"), NewClient(PLATFORM_FCM, "app1", &statusSender{
+		platform: PLATFORM_FCM,
+		statuses: map[string]DeliveryStatus{"shared": StatusInvalidToken, "dead": StatusInvalidToken},
+	}))
+	_ = a.AddClient(FCMClientKey("app2"), NewClient(PLATFORM_FCM, "app2", &statusSender{
+		platform: PLATFORM_FCM,
+		statuses: map[string]DeliveryStatus{"dead": StatusInvalidToken},
+	}))
+	var (
+		mutex       Sync.Mutex
+		invalidated []string
+	)
+	a.SetTokenInvalidator(TokenInvalidatorFunc(func(token string, platform int, reason string) {
+		mutex.Lock()
+		invalidated = append(invalidated, token)
+		mutex.Unlock()
+	}))
+
+	a.SendMessageForAllCtx(context.Background(), &Message{Tokens: []string{"shared", "dead"}})
+	if len(invalidated) != 1 || invalidated[0] != "dead" {
+		t.Errorf("invalidated = %v, want [dead]", invalidated)
+	}
+}
+
+func TestApnsResult(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   DeliveryStatus
+	}{
+		{apns2.ReasonUnregistered, StatusInvalidToken},
+		{apns2.ReasonExpiredToken, StatusInvalidToken},
+		{apns2.ReasonBadDeviceToken, StatusInvalidToken},
+		{apns2.ReasonDeviceTokenNotForTopic, StatusFailed},
+		{apns2.ReasonTooManyRequests, StatusFailed},
+	}
+	for _, tt := range tests {
+		res := apnsResult("token", &apns2.Response{StatusCode: 400, Reason: tt.reason}, nil)
+		if res.Status != tt.want || res.Reason != tt.reason {
+			t.Errorf("apnsResult(%s) = %+v, want %s", tt.reason, res, tt.want)
+		}
+	}
+}
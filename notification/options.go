@@ -0,0 +1,246 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+)
+
+// APNs header and aps values
+const (
+	APNsPriorityHigh = apns2.PriorityHigh
+	APNsPriorityLow  = apns2.PriorityLow
+
+	APNsPushTypeAlert        = string(apns2.PushTypeAlert)
+	APNsPushTypeBackground   = string(apns2.PushTypeBackground)
+	APNsPushTypeLiveActivity = string(apns2.PushTypeLiveActivity)
+	APNsPushTypeVoIP         = string(apns2.PushTypeVOIP)
+
+	LiveActivityEventUpdate = string(payload.LiveActivityEventUpdate)
+	LiveActivityEventEnd    = string(payload.LiveActivityEventEnd)
+
+	InterruptionLevelPassive       = string(payload.InterruptionLevelPassive)
+	InterruptionLevelActive        = string(payload.InterruptionLevelActive)
+	InterruptionLevelTimeSensitive = string(payload.InterruptionLevelTimeSensitive)
+	InterruptionLevelCritical      = string(payload.InterruptionLevelCritical)
+)
+
+// APNsOptions override APNs headers and aps fields, it is used by APNs client
+// and by FCM for iOS devices
+type APNsOptions struct {
+	// apns-priority, 10 (default) or 5
+	Priority int
+	// apns-push-type, default alert, background for silent push or
+	// liveactivity when LiveActivity is set. liveactivity and voip pushes are
+	// sent to the .push-type.liveactivity and .voip topics of the app.
+	PushType string
+	// apns-expiration, zero mean APNs does not store the notification
+	Expiration time.Time
+	// apns-collapse-id
+	CollapseID string
+	ThreadID   string
+	// passive, active, time-sensitive or critical
+	InterruptionLevel string
+	// 0..1, nil mean not set
+	RelevanceScore *float32
+	// silent push, title and body should be empty
+	ContentAvailable bool
+	// localized title and body
+	TitleLocKey  string
+	TitleLocArgs []string
+	BodyLocKey   string
+	BodyLocArgs  []string
+	// update or end a Live Activity, Tokens are activity push tokens
+	LiveActivity *LiveActivityOptions
+}
+
+// LiveActivityOptions is the aps of a Live Activity update, Title and Body of
+// message are shown as alert when set
+type LiveActivityOptions struct {
+	// LiveActivityEventUpdate (default) or LiveActivityEventEnd
+	Event string
+	// dynamic content of the activity, it must match ContentState of the app
+	ContentState map[string]interface{}
+	// time of the update, iOS ignore updates older than the shown one. Zero
+	// use send time.
+	Timestamp time.Time
+	// activity is shown as outdated after StaleDate
+	StaleDate time.Time
+	// ended activity is removed from lock screen at DismissalDate, zero let
+	// iOS keep it up to 4 hours
+	DismissalDate time.Time
+}
+
+// AndroidOptions override android fields of FCM message
+type AndroidOptions struct {
+	ChannelID string
+	// how long message is kept when device is offline, zero use FCM default
+	TTL         time.Duration
+	CollapseKey string
+	ClickAction string
+	Image       string
+	// #rrggbb
+	Color string
+	Icon  string
+	// message delivery priority, high or normal
+	Priority string
+	// notification display priority: PRIORITY_MIN, PRIORITY_LOW,
+	// PRIORITY_DEFAULT, PRIORITY_HIGH, PRIORITY_MAX
+	NotificationPriority string
+}
+
+// apnsOptions return APNs options of msg, never nil
+func (m *Message) apnsOptions() *APNsOptions {
+	if m.APNs != nil {
+		return m.APNs
+	}
+	return &APNsOptions{}
+}
+
+// androidOptions return Android options of msg, never nil
+func (m *Message) androidOptions() *AndroidOptions {
+	if m.Android != nil {
+		return m.Android
+	}
+	return &AndroidOptions{}
+}
+
+// apnsAps build aps dictionary from msg, custom data are not included
+func apnsAps(msg *Message) *payload.Payload {
+	opts := msg.apnsOptions()
+	pData := payload.NewPayload()
+	silent := opts.ContentAvailable && msg.Title == "" && msg.Body == ""
+	if opts.PushType == APNsPushTypeVoIP {
+		// PushKit get the whole payload, alert is only sent when set
+		silent = msg.Title == "" && msg.Body == ""
+	}
+	if live := opts.LiveActivity; live != nil {
+		// alert of activity update is optional and can not be modified
+		silent = msg.Title == "" && msg.Body == ""
+		if !silent {
+			pData.AlertTitle(msg.Title)
+			pData.AlertBody(msg.Body)
+		}
+		liveActivityAps(pData, live)
+	} else if !silent {
+		pData.AlertTitle(msg.Title)
+		pData.AlertBody(msg.Body)
+		pData.MutableContent()
+	}
+	if category := msg.apnsCategory(); category != "" {
+		pData.Category(category)
+	}
+	// silent push must not play a sound, SendMessageForAll set the default
+	// sound on every message
+	if msg.Sound != "" && !silent {
+		pData.Sound(msg.Sound)
+	}
+	if badge, err := strconv.Atoi(msg.Badge); err == nil {
+		pData.Badge(badge)
+	}
+	if opts.ContentAvailable {
+		pData.ContentAvailable()
+	}
+	if opts.ThreadID != "" {
+		pData.ThreadID(opts.ThreadID)
+	}
+	if opts.InterruptionLevel != "" {
+		pData.InterruptionLevel(payload.EInterruptionLevel(opts.InterruptionLevel))
+	}
+	if opts.RelevanceScore != nil {
+		pData.RelevanceScore(*opts.RelevanceScore)
+	}
+	if opts.TitleLocKey != "" {
+		pData.AlertTitleLocKey(opts.TitleLocKey)
+		pData.AlertTitleLocArgs(opts.TitleLocArgs)
+	}
+	if opts.BodyLocKey != "" {
+		pData.AlertLocKey(opts.BodyLocKey)
+		pData.AlertLocArgs(opts.BodyLocArgs)
+	}
+	return pData
+}
+
+// liveActivityAps add event, content-state and dates of a Live Activity
+func liveActivityAps(pData *payload.Payload, live *LiveActivityOptions) {
+	event := live.Event
+	if event == "" {
+		event = LiveActivityEventUpdate
+	}
+	pData.SetEvent(payload.ELiveActivityEvent(event))
+	if live.ContentState != nil {
+		pData.SetContentState(live.ContentState)
+	}
+	timestamp := live.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	pData.SetTimestamp(timestamp.Unix())
+	if !live.StaleDate.IsZero() {
+		pData.SetStaleDate(live.StaleDate.Unix())
+	}
+	if !live.DismissalDate.IsZero() {
+		pData.SetDismissalDate(live.DismissalDate.Unix())
+	}
+}
+
+// apnsPushType return push type header, silent push must use background
+func apnsPushType(msg *Message) string {
+	opts := msg.apnsOptions()
+	if opts.PushType != "" {
+		return opts.PushType
+	}
+	if opts.LiveActivity != nil {
+		return APNsPushTypeLiveActivity
+	}
+	if opts.ContentAvailable && msg.Title == "" && msg.Body == "" {
+		return APNsPushTypeBackground
+	}
+	return APNsPushTypeAlert
+}
+
+// apnsPriority return priority header, background push must use low priority
+func apnsPriority(msg *Message) int {
+	opts := msg.apnsOptions()
+	if opts.Priority != 0 {
+		return opts.Priority
+	}
+	if apnsPushType(msg) == APNsPushTypeBackground {
+		return APNsPriorityLow
+	}
+	return APNsPriorityHigh
+}
+
+// apnsHeaders return APNs headers used by FCM apns config
+func apnsHeaders(msg *Message) map[string]string {
+	opts := msg.apnsOptions()
+	headers := map[string]string{
+		"apns-priority":  strconv.Itoa(apnsPriority(msg)),
+		"apns-push-type": apnsPushType(msg),
+	}
+	if !opts.Expiration.IsZero() {
+		headers["apns-expiration"] = strconv.FormatInt(opts.Expiration.Unix(), 10)
+	}
+	if opts.CollapseID != "" {
+		headers["apns-collapse-id"] = opts.CollapseID
+	}
+	return headers
+}
+
+// fcmDuration format duration as protobuf Duration json ("3.5s")
+func fcmDuration(d time.Duration) string {
+	return fmt.Sprintf("%gs", d.Seconds())
+}
+
+// jsonArgs encode localization args as json array string, legacy FCM format
+func jsonArgs(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	raw, _ := json.Marshal(args)
+	return string(raw)
+}
@@ -0,0 +1,38 @@
+package notification
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApnsAps(t *testing.T) {
+	tests := []struct {
+		name      string
+		msg       *Message
+		wantAlert bool
+		wantSound bool
+	}{
+		{"alert", &Message{Title: "title", Sound: "default"}, true, true},
+		// SendMessageForAll set the default sound on silent pushes too
+		{"silent", &Message{Sound: "default", APNs: &APNsOptions{ContentAvailable: true}}, false, false},
+		{"content available alert", &Message{Body: "body", Sound: "default", APNs: &APNsOptions{ContentAvailable: true}}, true, true},
+		{"silent voip", &Message{Sound: "default", APNs: &APNsOptions{PushType: APNsPushTypeVoIP}}, false, false},
+	}
+	for _, tt := range tests {
+		encoded, err := json.Marshal(apnsAps(tt.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := struct {
+			Aps map[string]interface{} `json:"aps"`
+		}{}
+		if err := json.Unmarshal(encoded, &payload); err != nil {
+			t.Fatal(err)
+		}
+		_, alert := payload.Aps["alert"]
+		_, sound := payload.Aps["sound"]
+		if alert != tt.wantAlert || sound != tt.wantSound {
+			t.Errorf("%s: aps = %v, want alert %v, sound %v", tt.name, payload.Aps, tt.wantAlert, tt.wantSound)
+		}
+	}
+}
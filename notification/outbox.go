@@ -0,0 +1,444 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	Sync "sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//=================================================================
+// Outbox
+// - Messages are saved to a store before sending, a crash does not lose them
+// - Workers claim jobs with a lease, at-least-once delivery
+// - Jobs of a dead worker are claimed again when the lease expire
+// - Jobs with the same idempotency key are only enqueued once
+//=================================================================
+const (
+	OutboxPending = "pending"
+	OutboxDone    = "done"
+	// failed after the last attempt
+	OutboxDead = "dead"
+	// canceled before it was sent
+	OutboxCanceled = "canceled"
+
+	defaultOutboxBatchSize    = 10
+	defaultOutboxLease        = 5 * time.Minute
+	defaultOutboxPollInterval = time.Second
+)
+
+var (
+	// ErrOutboxJobLost is returned by OutboxStore.Update when job lease
+	// expired and the job was claimed by another worker
+	ErrOutboxJobLost     = errors.New("notification: outbox job was claimed by another worker")
+	ErrOutboxJobNotFound = errors.New("notification: outbox job not found")
+	// job is already sent, being sent or canceled
+	ErrOutboxJobNotCancelable = errors.New("notification: outbox job can not be canceled")
+)
+
+// defaultOutboxRetry retry a job for about a day
+var defaultOutboxRetry = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelayMs: 5000,
+	MaxDelayMs:  int(3 * time.Hour / time.Millisecond),
+	Jitter:      0.2,
+}
+
+// OutboxJob is a message waiting to be sent. Message is sent with
+// SendToRecipients when it has Recipients, through ClientKey when it is set,
+// to every client of Platform when it is set, otherwise to every push client.
+type OutboxJob struct {
+	ID string
+	// jobs with the same key are enqueued once, default to ID
+	IdempotencyKey string
+	Platform       int
+	ClientKey      string
+	Message        *Message
+	// tokens to retry by client key, set when a job sent to many clients
+	// failed so clients which delivered a token do not get it again
+	RetryTokens map[string][]string
+
+	Status   string
+	Attempts int
+	// job is not claimed before this time
+	NextAttemptAt time.Time
+	// worker holding the job and end of its lease
+	ClaimedBy   string
+	LockedUntil time.Time
+	LastError   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// OutboxStore persist outbox jobs, it must be safe for concurrent use by
+// workers of many processes
+type OutboxStore interface {
+	// Add save a new pending job, if a job with the same idempotency key
+	// exists it is returned instead with created false
+	Add(ctx context.Context, job *OutboxJob) (saved *OutboxJob, created bool, err error)
+	// Claim lock at most limit pending jobs which are due at now and not
+	// claimed (or whose lease expired) for worker until now+lease
+	Claim(ctx context.Context, worker string, now time.Time, limit int, lease time.Duration) ([]*OutboxJob, error)
+	// Update save pending job claimed by job.ClaimedBy and release the
+	// claim, it return ErrOutboxJobLost if the job is not claimed by that
+	// worker any more or was canceled
+	Update(ctx context.Context, job *OutboxJob) error
+	// Get return job with id or ErrOutboxJobNotFound
+	Get(ctx context.Context, id string) (*OutboxJob, error)
+	// Cancel mark pending job which is not claimed at now as canceled and
+	// drop its claim, it return ErrOutboxJobNotCancelable otherwise
+	Cancel(ctx context.Context, id string, now time.Time) error
+}
+
+type OutboxOptions struct {
+	// jobs claimed by a worker at once, default 10
+	BatchSize int
+	// how long a claimed job is hidden from other workers, it must be longer
+	// than sending a batch, default 5 minutes
+	Lease time.Duration
+	// wait between polls when there is no due job, default 1s
+	PollInterval time.Duration
+	// attempts of job and delay between them, default 10 attempts from 5s
+	// to 3h. Only tokens which failed with a retryable result are retried.
+	Retry *RetryPolicy
+}
+
+type Outbox struct {
+	app     *AppNotification
+	store   OutboxStore
+	options OutboxOptions
+	retry   RetryPolicy
+	worker  string
+	now     func() time.Time
+}
+
+// NewOutbox create outbox sending jobs of store through app
+func NewOutbox(app *AppNotification, store OutboxStore, options OutboxOptions) *Outbox {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultOutboxBatchSize
+	}
+	if options.Lease <= 0 {
+		options.Lease = defaultOutboxLease
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultOutboxPollInterval
+	}
+	retry := defaultOutboxRetry
+	if options.Retry != nil {
+		retry = *options.Retry
+	}
+	hostname, _ := os.Hostname()
+	return &Outbox{
+		app:     app,
+		store:   store,
+		options: options,
+		retry:   retry,
+		worker:  fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8]),
+		now:     time.Now,
+	}
+}
+
+// Enqueue save job to be sent by workers, ID, Status and times are set by
+// outbox. If a job with the same idempotency key was enqueued before, that
+// job is returned and nothing is added.
+func (o *Outbox) Enqueue(ctx context.Context, job OutboxJob) (*OutboxJob, error) {
+	if job.Message == nil {
+		return nil, errors.New("notification: outbox job without message")
+	}
+	now := o.now()
+	job.ID = uuid.New().String()
+	if job.IdempotencyKey == "" {
+		job.IdempotencyKey = job.ID
+	}
+	job.Status = OutboxPending
+	job.Attempts = 0
+	if job.NextAttemptAt.IsZero() {
+		job.NextAttemptAt = now
+	}
+	job.ClaimedBy = ""
+	job.LockedUntil = time.Time{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	saved, created, err := o.store.Add(ctx, &job)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		o.app.log().Info("outbox: job already exists", "idempotency_key", job.IdempotencyKey)
+	}
+	return saved, nil
+}
+
+// Run start workers and block until ctx is done and every worker stopped.
+// A job being sent when ctx is done is left claimed and sent again by a
+// worker after its lease expire.
+func (o *Outbox) Run(ctx context.Context, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	wg := &Sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				processed, err := o.ProcessOnce(ctx)
+				if err != nil {
+					o.app.log().Error("outbox: process failed", "error", err)
+				}
+				if processed > 0 && err == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(o.options.PollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ProcessOnce claim one batch of due jobs, send them and return how many
+// jobs were claimed
+func (o *Outbox) ProcessOnce(ctx context.Context) (int, error) {
+	jobs, err := o.store.Claim(ctx, o.worker, o.now(), o.options.BatchSize, o.options.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := o.process(ctx, job); err != nil {
+			o.app.log().Error("outbox: job failed", "job", job.ID, "error", err)
+		}
+	}
+	return len(jobs), nil
+}
+
+// process send job and save its result, retryable failures are kept in the
+// job for the next attempt
+func (o *Outbox) process(ctx context.Context, job *OutboxJob) error {
+	report := o.send(ctx, job)
+	if ctx.Err() != nil {
+		// shutting down, results may be incomplete: keep the claim so the
+		// job is sent again after lease
+		return ctx.Err()
+	}
+
+	job.Attempts++
+	job.UpdatedAt = o.now()
+	job.LastError = ""
+	retry := map[string]bool{}
+	retryTokens := map[string][]string{}
+	var retryAfter time.Duration
+	// a token delivered by one client belongs to its app, failures of the
+	// other clients are not retried
+	delivered := map[string]bool{}
+	for _, res := range report.Results {
+		if res.Sent() {
+			delivered[res.Token] = true
+		}
+	}
+	for _, res := range report.Results {
+		if res.Status != StatusFailed {
+			continue
+		}
+		job.LastError = res.Reason
+		if res.Err != nil {
+			job.LastError = res.Err.Error()
+		}
+		if res.Retryable && !delivered[res.Token] {
+			retry[res.Token] = true
+			retryTokens[res.ClientKey] = append(retryTokens[res.ClientKey], res.Token)
+			if res.RetryAfter > retryAfter {
+				retryAfter = res.RetryAfter
+			}
+		}
+	}
+
+	switch {
+	case len(retry) == 0:
+		job.Status = OutboxDone
+	case job.Attempts >= o.retry.MaxAttempts:
+		job.Status = OutboxDead
+		o.app.log().Error("outbox: job is dead", "job", job.ID, "platform", platformName(job.Platform), "client", job.ClientKey, "attempts", job.Attempts, "error", job.LastError)
+	default:
+		job.Message = retryMessage(job.Message, retry)
+		job.RetryTokens = nil
+		if job.sentToClients() {
+			job.RetryTokens = retryTokens
+		}
+		job.NextAttemptAt = job.UpdatedAt.Add(o.retry.backoff(job.Attempts, retryAfter))
+	}
+	return o.store.Update(ctx, job)
+}
+
+// sentToClients return true when job token message is sent to every client
+// of a platform, failed tokens are then retried per client
+func (j *OutboxJob) sentToClients() bool {
+	return j.ClientKey == "" && len(j.Message.Recipients) == 0 && !j.Message.IsTopicMessage()
+}
+
+func (o *Outbox) send(ctx context.Context, job *OutboxJob) *DeliveryReport {
+	msg := job.Message
+	switch {
+	case len(job.RetryTokens) > 0 && job.sentToClients():
+		return o.sendRetryTokens(ctx, job)
+	case len(msg.Recipients) > 0:
+		return o.app.SendToRecipients(ctx, msg)
+	case job.ClientKey != "":
+		return o.app.SendMessageCtx(ctx, job.Platform, job.ClientKey, msg)
+	case job.Platform != 0:
+		return o.app.sendMessageFor(ctx, msg, func(client *Client) bool {
+			return client.Platform == job.Platform
+		})
+	}
+	return o.app.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return isPushPlatform(client.Platform)
+	})
+}
+
+// sendRetryTokens send failed tokens of the previous attempt to their
+// client only, a client removed meanwhile fail them for good
+func (o *Outbox) sendRetryTokens(ctx context.Context, job *OutboxJob) *DeliveryReport {
+	report := &DeliveryReport{}
+	keys := make([]string, 0, len(job.RetryTokens))
+	for key := range job.RetryTokens {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		msg := *job.Message
+		msg.Tokens = job.RetryTokens[key]
+		report.merge(o.app.sendMessage(ctx, job.Platform, key, &msg))
+	}
+	o.app.invalidateTokens(report)
+	return report
+}
+
+// retryMessage return copy of msg with only tokens and recipients to retry,
+// topic messages are retried as they are
+func retryMessage(msg *Message, retry map[string]bool) *Message {
+	next := *msg
+	if msg.IsTopicMessage() {
+		return &next
+	}
+	next.Tokens = nil
+	for _, token := range msg.Tokens {
+		if retry[token] {
+			next.Tokens = append(next.Tokens, token)
+		}
+	}
+	next.Recipients = nil
+	for _, recipient := range msg.Recipients {
+		if retry[recipient.Address] {
+			next.Recipients = append(next.Recipients, recipient)
+		}
+	}
+	return &next
+}
+
+// MemoryOutboxStore keep jobs in memory, jobs are lost on restart so it
+// is for tests and apps which only need the worker and retry part
+type MemoryOutboxStore struct {
+	mutex Sync.Mutex
+	jobs  map[string]*OutboxJob
+	keys  map[string]string
+}
+
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		jobs: map[string]*OutboxJob{},
+		keys: map[string]string{},
+	}
+}
+
+func (s *MemoryOutboxStore) Add(ctx context.Context, job *OutboxJob) (*OutboxJob, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if id, found := s.keys[job.IdempotencyKey]; found {
+		existing := *s.jobs[id]
+		return &existing, false, nil
+	}
+	saved := *job
+	s.jobs[job.ID] = &saved
+	s.keys[job.IdempotencyKey] = job.ID
+	out := saved
+	return &out, true, nil
+}
+
+func (s *MemoryOutboxStore) Claim(ctx context.Context, worker string, now time.Time, limit int, lease time.Duration) ([]*OutboxJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var due []*OutboxJob
+	for _, job := range s.jobs {
+		if job.Status == OutboxPending && !job.NextAttemptAt.After(now) && !job.LockedUntil.After(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*OutboxJob, 0, len(due))
+	for _, job := range due {
+		job.ClaimedBy = worker
+		job.LockedUntil = now.Add(lease)
+		out := *job
+		claimed = append(claimed, &out)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryOutboxStore) Update(ctx context.Context, job *OutboxJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, found := s.jobs[job.ID]
+	if !found {
+		return ErrOutboxJobNotFound
+	}
+	if current.ClaimedBy != job.ClaimedBy || current.Status != OutboxPending {
+		return ErrOutboxJobLost
+	}
+	saved := *job
+	saved.ClaimedBy = ""
+	saved.LockedUntil = time.Time{}
+	s.jobs[job.ID] = &saved
+	return nil
+}
+
+func (s *MemoryOutboxStore) Get(ctx context.Context, id string) (*OutboxJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, found := s.jobs[id]
+	if !found {
+		return nil, ErrOutboxJobNotFound
+	}
+	out := *job
+	return &out, nil
+}
+
+func (s *MemoryOutboxStore) Cancel(ctx context.Context, id string, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, found := s.jobs[id]
+	if !found {
+		return ErrOutboxJobNotFound
+	}
+	if job.Status != OutboxPending || job.LockedUntil.After(now) {
+		return ErrOutboxJobNotCancelable
+	}
+	job.Status = OutboxCanceled
+	job.ClaimedBy = ""
+	job.UpdatedAt = now
+	return nil
+}
@@ -0,0 +1,254 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GormOutboxStore keep outbox jobs in a SQL table through gorm, workers of
+// many processes can share it. Claim use conditional updates so it work on
+// every database supported by gorm.
+type GormOutboxStore struct {
+	db *gorm.DB
+}
+
+// outboxRecord is the row of an outbox job, message and retry tokens are
+// saved as json. LockedUntil is NULL when the job is not claimed.
+type outboxRecord struct {
+	ID             string     `gorm:"primary_key;type:varchar(36)"`
+	IdempotencyKey string     `gorm:"type:varchar(255);unique_index;not null"`
+	Platform       int        `gorm:"not null;default:0"`
+	ClientKey      string     `gorm:"type:varchar(255)"`
+	Message        string     `gorm:"type:text;not null"`
+	RetryTokens    string     `gorm:"type:text"`
+	Status         string     `gorm:"type:varchar(16);index;not null"`
+	Attempts       int        `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time  `gorm:"index;not null"`
+	ClaimedBy      string     `gorm:"type:varchar(255)"`
+	LockedUntil    *time.Time `gorm:"index"`
+	LastError      string     `gorm:"type:text"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (outboxRecord) TableName() string {
+	return "notification_outbox"
+}
+
+func NewGormOutboxStore(db *gorm.DB) *GormOutboxStore {
+	return &GormOutboxStore{db: db}
+}
+
+// AutoMigrate create or update notification_outbox table
+func (s *GormOutboxStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&outboxRecord{}).Error
+}
+
+func (s *GormOutboxStore) Add(ctx context.Context, job *OutboxJob) (*OutboxJob, bool, error) {
+	record, err := newOutboxRecord(job)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing, found, err := s.findByKey(ctx, job.IdempotencyKey); err != nil || found {
+		return existing, false, err
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		// another process may have added the same key since findByKey
+		if existing, found, findErr := s.findByKey(ctx, job.IdempotencyKey); findErr == nil && found {
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+	saved, err := record.job()
+	return saved, true, err
+}
+
+func (s *GormOutboxStore) findByKey(ctx context.Context, key string) (*OutboxJob, bool, error) {
+	record := &outboxRecord{}
+	err := s.db.Where("idempotency_key = ?", key).First(record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	job, err := record.job()
+	return job, true, err
+}
+
+func (s *GormOutboxStore) Claim(ctx context.Context, worker string, now time.Time, limit int, lease time.Duration) ([]*OutboxJob, error) {
+	var candidates []*outboxRecord
+	err := s.db.
+		Where("status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until <= ?)", OutboxPending, now, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*OutboxJob, 0, len(candidates))
+	lockedUntil := now.Add(lease)
+	for _, record := range candidates {
+		// only one worker win the row, the others see 0 rows affected
+		result := s.db.Model(&outboxRecord{}).
+			Where("id = ? AND status = ? AND (locked_until IS NULL OR locked_until <= ?)", record.ID, OutboxPending, now).
+			Updates(map[string]interface{}{
+				"claimed_by":   worker,
+				"locked_until": lockedUntil,
+			})
+		if result.Error != nil {
+			return jobs, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		record.ClaimedBy = worker
+		record.LockedUntil = &lockedUntil
+		job, err := record.job()
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *GormOutboxStore) Update(ctx context.Context, job *OutboxJob) error {
+	rawMessage, err := json.Marshal(job.Message)
+	if err != nil {
+		return err
+	}
+	retryTokens, err := marshalRetryTokens(job.RetryTokens)
+	if err != nil {
+		return err
+	}
+	result := s.db.Model(&outboxRecord{}).
+		Where("id = ? AND claimed_by = ? AND status = ?", job.ID, job.ClaimedBy, OutboxPending).
+		Updates(map[string]interface{}{
+			"message":         string(rawMessage),
+			"retry_tokens":    retryTokens,
+			"status":          job.Status,
+			"attempts":        job.Attempts,
+			"next_attempt_at": job.NextAttemptAt,
+			"claimed_by":      "",
+			"locked_until":    nil,
+			"last_error":      job.LastError,
+			"updated_at":      job.UpdatedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOutboxJobLost
+	}
+	return nil
+}
+
+func (s *GormOutboxStore) Get(ctx context.Context, id string) (*OutboxJob, error) {
+	record := &outboxRecord{}
+	err := s.db.Where("id = ?", id).First(record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrOutboxJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.job()
+}
+
+func (s *GormOutboxStore) Cancel(ctx context.Context, id string, now time.Time) error {
+	result := s.db.Model(&outboxRecord{}).
+		Where("id = ? AND status = ? AND (locked_until IS NULL OR locked_until <= ?)", id, OutboxPending, now).
+		Updates(map[string]interface{}{
+			"status":     OutboxCanceled,
+			"claimed_by": "",
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrOutboxJobNotCancelable
+	}
+	return nil
+}
+
+func newOutboxRecord(job *OutboxJob) (*outboxRecord, error) {
+	rawMessage, err := json.Marshal(job.Message)
+	if err != nil {
+		return nil, err
+	}
+	retryTokens, err := marshalRetryTokens(job.RetryTokens)
+	if err != nil {
+		return nil, err
+	}
+	var lockedUntil *time.Time
+	if !job.LockedUntil.IsZero() {
+		lockedUntil = &job.LockedUntil
+	}
+	return &outboxRecord{
+		ID:             job.ID,
+		IdempotencyKey: job.IdempotencyKey,
+		Platform:       job.Platform,
+		ClientKey:      job.ClientKey,
+		Message:        string(rawMessage),
+		RetryTokens:    retryTokens,
+		Status:         job.Status,
+		Attempts:       job.Attempts,
+		NextAttemptAt:  job.NextAttemptAt,
+		ClaimedBy:      job.ClaimedBy,
+		LockedUntil:    lockedUntil,
+		LastError:      job.LastError,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+	}, nil
+}
+
+func (r *outboxRecord) job() (*OutboxJob, error) {
+	msg := &Message{}
+	if err := json.Unmarshal([]byte(r.Message), msg); err != nil {
+		return nil, err
+	}
+	var retryTokens map[string][]string
+	if r.RetryTokens != "" {
+		if err := json.Unmarshal([]byte(r.RetryTokens), &retryTokens); err != nil {
+			return nil, err
+		}
+	}
+	var lockedUntil time.Time
+	if r.LockedUntil != nil {
+		lockedUntil = *r.LockedUntil
+	}
+	return &OutboxJob{
+		ID:             r.ID,
+		IdempotencyKey: r.IdempotencyKey,
+		Platform:       r.Platform,
+		ClientKey:      r.ClientKey,
+		Message:        msg,
+		RetryTokens:    retryTokens,
+		Status:         r.Status,
+		Attempts:       r.Attempts,
+		NextAttemptAt:  r.NextAttemptAt,
+		ClaimedBy:      r.ClaimedBy,
+		LockedUntil:    lockedUntil,
+		LastError:      r.LastError,
+		CreatedAt:      r.CreatedAt,
+		UpdatedAt:      r.UpdatedAt,
+	}, nil
+}
+
+// marshalRetryTokens return json of tokens, empty string when there is none
+func marshalRetryTokens(tokens map[string][]string) (string, error) {
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(tokens)
+	return string(raw), err
+}
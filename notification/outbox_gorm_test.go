@@ -0,0 +1,44 @@
+package notification
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+func newTestGormOutboxStore(t *testing.T) *GormOutboxStore {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// every connection of :memory: is a new database
+	db.DB().SetMaxOpenConns(1)
+	store := NewGormOutboxStore(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestGormOutboxStore(t *testing.T) {
+	store := newTestGormOutboxStore(t)
+	testOutboxStore(t, store)
+	testOutboxRetryClients(t, newTestGormOutboxStore(t))
+
+	// job which is not claimed has no lock
+	ctx := context.Background()
+	now := time.Now()
+	_, _, err := store.Add(ctx, &OutboxJob{ID: "job-4", IdempotencyKey: "key-4", Message: &Message{}, Status: OutboxPending, NextAttemptAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unlocked int
+	store.db.Model(&outboxRecord{}).Where("id = ? AND locked_until IS NULL", "job-4").Count(&unlocked)
+	if unlocked != 1 {
+		t.Errorf("locked_until of new job is not NULL")
+	}
+}
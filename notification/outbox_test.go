@@ -0,0 +1,218 @@
+package notification
+
+import (
+	"context"
+	Sync "sync"
+	"testing"
+	"time"
+)
+
+// flakySender fail token "b" with a retryable error on its first attempt,
+// or on every attempt without retry when permanent is set
+type flakySender struct {
+	mutex     Sync.Mutex
+	attempts  map[string]int
+	permanent bool
+}
+
+func (s *flakySender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report := &DeliveryReport{}
+	for _, token := range msg.Tokens {
+		s.attempts[token]++
+		res := TokenResult{Token: token, Platform: PLATFORM_FCM, Status: StatusSent}
+		switch {
+		case token == "b" && s.permanent:
+			res.Status = StatusFailed
+			res.Reason = "SenderIdMismatch"
+		case token == "b" && s.attempts[token] == 1:
+			res.Status = StatusFailed
+			res.Retryable = true
+			res.Reason = "Unavailable"
+		}
+		report.add(res)
+	}
+	return report, nil
+}
+
+func TestOutbox(t *testing.T) {
+	sender := &flakySender{attempts: map[string]int{}}
+	a, _ := NewAppNotification(Config{})
+	_ = a.AddClient(FCMClientKey("app"), NewClient(PLATFORM_FCM, "app", sender))
+	store := NewMemoryOutboxStore()
+	outbox := NewOutbox(a, store, OutboxOptions{Retry: &RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1, MaxDelayMs: 1}})
+	ctx := context.Background()
+
+	job, err := outbox.Enqueue(ctx, OutboxJob{
+		IdempotencyKey: "order-1-shipped",
+		Platform:       PLATFORM_FCM,
+		Message:        &Message{Title: "shipped", Tokens: []string{"a", "b"}},
+	})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	again, _ := outbox.Enqueue(ctx, OutboxJob{IdempotencyKey: "order-1-shipped", Message: &Message{}})
+	if again.ID != job.ID {
+		t.Errorf("Enqueue() with same key created job %s, want %s", again.ID, job.ID)
+	}
+
+	// a claimed job is hidden from other workers until its lease expire
+	other := NewOutbox(a, store, OutboxOptions{Lease: time.Hour})
+	if claimed, _ := store.Claim(ctx, "other", time.Now(), 10, 50*time.Millisecond); len(claimed) != 1 {
+		t.Fatalf("Claim() = %d jobs", len(claimed))
+	}
+	if n, _ := other.ProcessOnce(ctx); n != 0 {
+		t.Errorf("ProcessOnce() claimed %d jobs under lease", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	if n, err := outbox.ProcessOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("ProcessOnce() = %d, %v", n, err)
+	}
+	saved, _ := store.Get(ctx, job.ID)
+	if saved.Status != OutboxPending || saved.Attempts != 1 || len(saved.Message.Tokens) != 1 || saved.Message.Tokens[0] != "b" {
+		t.Fatalf("job after first attempt = %+v", saved)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if n, err := outbox.ProcessOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("ProcessOnce() = %d, %v", n, err)
+	}
+	saved, _ = store.Get(ctx, job.ID)
+	if saved.Status != OutboxDone || saved.Attempts != 2 {
+		t.Errorf("job after retry = %+v", saved)
+	}
+	if sender.attempts["a"] != 1 || sender.attempts["b"] != 2 {
+		t.Errorf("attempts = %v, want a sent once and b twice", sender.attempts)
+	}
+}
+
+func TestMemoryOutboxStore(t *testing.T) {
+	testOutboxStore(t, NewMemoryOutboxStore())
+	testOutboxRetryClients(t, NewMemoryOutboxStore())
+}
+
+// testOutboxStore check claim, lease, update and cancel of store
+func testOutboxStore(t *testing.T, store OutboxStore) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	job := &OutboxJob{
+		ID:             "job-1",
+		IdempotencyKey: "key-1",
+		Platform:       PLATFORM_FCM,
+		Message:        &Message{Title: "title", Tokens: []string{"a"}},
+		Status:         OutboxPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, created, err := store.Add(ctx, job); !created || err != nil {
+		t.Fatalf("Add() = %v, %v", created, err)
+	}
+	duplicate := *job
+	duplicate.ID = "job-2"
+	if saved, created, err := store.Add(ctx, &duplicate); created || err != nil || saved.ID != job.ID {
+		t.Fatalf("Add() of same key = %+v, %v, %v", saved, created, err)
+	}
+
+	claimed, err := store.Claim(ctx, "w1", now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ClaimedBy != "w1" || !claimed[0].LockedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Claim() = %+v, %v", claimed, err)
+	}
+	if again, _ := store.Claim(ctx, "w2", now.Add(time.Second), 10, time.Minute); len(again) != 0 {
+		t.Errorf("Claim() under lease = %d jobs", len(again))
+	}
+	if err := store.Cancel(ctx, job.ID, now); err != ErrOutboxJobNotCancelable {
+		t.Errorf("Cancel() of claimed job error = %v", err)
+	}
+
+	// lease expired, another worker take the job and the first one lose it
+	stolen, _ := store.Claim(ctx, "w2", now.Add(2*time.Minute), 10, time.Minute)
+	if len(stolen) != 1 {
+		t.Fatalf("Claim() after lease = %d jobs", len(stolen))
+	}
+	claimed[0].Status = OutboxDone
+	if err := store.Update(ctx, claimed[0]); err != ErrOutboxJobLost {
+		t.Errorf("Update() by lost worker error = %v", err)
+	}
+	job2 := stolen[0]
+	job2.Attempts = 1
+	job2.Message.Tokens = []string{"b"}
+	job2.RetryTokens = map[string][]string{"FCM_app": {"b"}}
+	job2.NextAttemptAt = now.Add(time.Hour)
+	if err := store.Update(ctx, job2); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	saved, err := store.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ClaimedBy != "" || !saved.LockedUntil.IsZero() || saved.Attempts != 1 ||
+		len(saved.RetryTokens["FCM_app"]) != 1 || saved.Message.Tokens[0] != "b" {
+		t.Errorf("job after Update() = %+v", saved)
+	}
+	if due, _ := store.Claim(ctx, "w1", now.Add(3*time.Minute), 10, time.Minute); len(due) != 0 {
+		t.Errorf("Claim() before next attempt = %d jobs", len(due))
+	}
+
+	if err := store.Cancel(ctx, job.ID, now); err != nil {
+		t.Errorf("Cancel() error = %v", err)
+	}
+	if saved, _ := store.Get(ctx, job.ID); saved.Status != OutboxCanceled {
+		t.Errorf("status after Cancel() = %s", saved.Status)
+	}
+	// worker whose lease expired can not overwrite a cancel
+	_, _, _ = store.Add(ctx, &OutboxJob{ID: "job-3", IdempotencyKey: "key-3", Message: &Message{}, Status: OutboxPending, NextAttemptAt: now})
+	late, _ := store.Claim(ctx, "w1", now, 10, time.Minute)
+	if len(late) != 1 {
+		t.Fatalf("Claim() = %d jobs", len(late))
+	}
+	if err := store.Cancel(ctx, "job-3", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("Cancel() after lease error = %v", err)
+	}
+	late[0].Status = OutboxDone
+	if err := store.Update(ctx, late[0]); err != ErrOutboxJobLost {
+		t.Errorf("Update() of canceled job error = %v, want %v", err, ErrOutboxJobLost)
+	}
+	if saved, _ := store.Get(ctx, "job-3"); saved.Status != OutboxCanceled || saved.ClaimedBy != "" {
+		t.Errorf("canceled job = %+v", saved)
+	}
+
+	if err := store.Cancel(ctx, "missing", now); err != ErrOutboxJobNotFound {
+		t.Errorf("Cancel() of unknown job error = %v", err)
+	}
+	if _, err := store.Get(ctx, "missing"); err != ErrOutboxJobNotFound {
+		t.Errorf("Get() of unknown job error = %v", err)
+	}
+}
+
+// testOutboxRetryClients check a job sent to many clients only retry
+// tokens of the clients which failed
+func testOutboxRetryClients(t *testing.T, store OutboxStore) {
+	app1 := &flakySender{attempts: map[string]int{}}
+	app2 := &flakySender{attempts: map[string]int{}, permanent: true}
+	a, _ := NewAppNotification(Config{})
+	_ = a.AddClient(FCMClientKey("app1"), NewClient(PLATFORM_FCM, "app1", app1))
+	_ = a.AddClient(FCMClientKey("app2"), NewClient(PLATFORM_FCM, "app2", app2))
+	outbox := NewOutbox(a, store, OutboxOptions{Retry: &RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1, MaxDelayMs: 1}})
+	ctx := context.Background()
+
+	job, err := outbox.Enqueue(ctx, OutboxJob{Platform: PLATFORM_FCM, Message: &Message{Tokens: []string{"a", "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if n, err := outbox.ProcessOnce(ctx); n != 1 || err != nil {
+			t.Fatalf("ProcessOnce() attempt %d = %d, %v", attempt, n, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	saved, _ := store.Get(ctx, job.ID)
+	if saved.Status != OutboxDone || saved.Attempts != 2 {
+		t.Errorf("job = %+v", saved)
+	}
+	if app1.attempts["a"] != 1 || app1.attempts["b"] != 2 || app2.attempts["a"] != 1 || app2.attempts["b"] != 1 {
+		t.Errorf("attempts app1 = %v, app2 = %v, want only b of app1 retried", app1.attempts, app2.attempts)
+	}
+}
@@ -0,0 +1,89 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// max payload size accepted by providers
+const (
+	APNsMaxPayloadSize = 4096
+	FCMMaxPayloadSize  = 4096
+)
+
+var (
+	ErrInvalidPayload  = errors.New("notification: payload data must encode to a JSON object")
+	ErrPayloadTooLarge = errors.New("notification: payload too large")
+)
+
+// PayloadError is returned when PayloadData can not be encoded for a
+// platform, use errors.Is with ErrInvalidPayload or ErrPayloadTooLarge
+type PayloadError struct {
+	Platform int
+	// encoded size and limit of provider, zero for ErrInvalidPayload
+	Size  int
+	Limit int
+	Err   error
+}
+
+func (e *PayloadError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("%s: %d bytes, platform %d limit %d bytes", e.Err.Error(), e.Size, e.Platform, e.Limit)
+	}
+	return e.Err.Error()
+}
+
+func (e *PayloadError) Unwrap() error {
+	return e.Err
+}
+
+// encodePayloadData convert PayloadData to a map. It accept nil, maps,
+// json.RawMessage/[]byte of a JSON object and any struct encodable to a JSON
+// object.
+func encodePayloadData(platform int, data interface{}) (map[string]interface{}, error) {
+	var raw []byte
+	switch value := data.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return value, nil
+	case map[string]string:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[k] = v
+		}
+		return result, nil
+	case json.RawMessage:
+		raw = value
+	case []byte:
+		raw = value
+	default:
+		var err error
+		raw, err = json.Marshal(value)
+		if err != nil {
+			return nil, &PayloadError{Platform: platform, Err: fmt.Errorf("%w: %s", ErrInvalidPayload, err.Error())}
+		}
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, &PayloadError{Platform: platform, Err: ErrInvalidPayload}
+	}
+	return result, nil
+}
+
+// checkPayloadSize return PayloadError when encoded payload exceed limit
+func checkPayloadSize(platform int, encoded []byte, limit int) error {
+	if len(encoded) > limit {
+		return &PayloadError{
+			Platform: platform,
+			Size:     len(encoded),
+			Limit:    limit,
+			Err:      ErrPayloadTooLarge,
+		}
+	}
+	return nil
+}
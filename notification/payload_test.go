@@ -0,0 +1,63 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodePayloadData(t *testing.T) {
+	type order struct {
+		OrderID string `json:"order_id"`
+	}
+	tests := []struct {
+		name    string
+		data    interface{}
+		want    string
+		wantErr error
+	}{
+		{name: "nil", data: nil, want: "null"},
+		{name: "map", data: map[string]interface{}{"order_id": "1"}, want: `{"order_id":"1"}`},
+		{name: "string map", data: map[string]string{"order_id": "1"}, want: `{"order_id":"1"}`},
+		{name: "raw message", data: json.RawMessage(`{"order_id":"1"}`), want: `{"order_id":"1"}`},
+		{name: "struct", data: order{OrderID: "1"}, want: `{"order_id":"1"}`},
+		{name: "struct pointer", data: &order{OrderID: "1"}, want: `{"order_id":"1"}`},
+		{name: "array", data: []string{"1"}, wantErr: ErrInvalidPayload},
+		{name: "invalid raw message", data: json.RawMessage(`{`), wantErr: ErrInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodePayloadData(PLATFORM_APNs, tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("encodePayloadData() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("encodePayloadData() error = %v", err)
+			}
+			if raw, _ := json.Marshal(got); string(raw) != tt.want {
+				t.Errorf("encodePayloadData() = %s, want %s", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPNsSender_PayloadTooLarge(t *testing.T) {
+	sender := NewAPNsSender(nil, &APNsConfig{AppBundleID: "com.example.app"})
+	_, err := sender.Send(context.Background(), &Message{
+		Title:       "title",
+		PayloadData: map[string]interface{}{"data": strings.Repeat("a", APNsMaxPayloadSize)},
+		Tokens:      []string{"token"},
+	})
+	payloadErr := &PayloadError{}
+	if !errors.As(err, &payloadErr) || !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Send() error = %v, want PayloadError", err)
+	}
+	if payloadErr.Limit != APNsMaxPayloadSize {
+		t.Errorf("Limit = %d, want %d", payloadErr.Limit, APNsMaxPayloadSize)
+	}
+}
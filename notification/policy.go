@@ -0,0 +1,442 @@
+package notification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	Sync "sync"
+	"time"
+)
+
+//=================================================================
+// Send policies, checked for every token before calling provider
+// - Token bucket rate limit per user, token and client
+// - Quiet hours in user timezone, deferred through outbox when set
+// - Same message to the same token is sent once per dedup window
+// Tokens which are not sent are reported with StatusSkipped.
+//=================================================================
+const (
+	SkipRateLimited = "RateLimited"
+	SkipQuietHours  = "QuietHours"
+	SkipDuplicate   = "Duplicate"
+)
+
+type PolicyConfig struct {
+	UserRateLimit  *RateLimit `json:"user_rate_limit"`
+	TokenRateLimit *RateLimit `json:"token_rate_limit"`
+	// per client (app), protect provider quota
+	AppRateLimit *RateLimit  `json:"app_rate_limit"`
+	QuietHours   *QuietHours `json:"quiet_hours"`
+	// identical message to the same token within window is skipped, 0 disable
+	DedupWindowSeconds int `json:"dedup_window_seconds"`
+}
+
+// RateLimit allow Count sends per PeriodSeconds, with bursts up to Burst
+type RateLimit struct {
+	Count         int `json:"count"`
+	PeriodSeconds int `json:"period_seconds"`
+	// bucket size, default Count
+	Burst int `json:"burst"`
+}
+
+// QuietHours is a local time window, "22:00" to "07:00" cross midnight
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// used when timezone of user is unknown, default UTC
+	DefaultTimezone string `json:"default_timezone"`
+}
+
+// TokenOwnerResolver return user and timezone of a token, policies use it
+// when Message.UserID / Message.Timezone and recipient do not tell them
+type TokenOwnerResolver interface {
+	TokenOwner(ctx context.Context, platform int, token string) (userID string, timezone string, found bool)
+}
+
+func (c *PolicyConfig) validate() []string {
+	var problems []string
+	for _, limit := range []struct {
+		name  string
+		limit *RateLimit
+	}{
+		{"user_rate_limit", c.UserRateLimit},
+		{"token_rate_limit", c.TokenRateLimit},
+		{"app_rate_limit", c.AppRateLimit},
+	} {
+		if limit.limit == nil {
+			continue
+		}
+		if limit.limit.Count <= 0 || limit.limit.PeriodSeconds <= 0 {
+			problems = append(problems, limit.name+": count and period_seconds must be positive")
+		}
+		if limit.limit.Burst < 0 {
+			problems = append(problems, limit.name+": burst must not be negative")
+		}
+	}
+	if c.QuietHours != nil {
+		if _, err := parseClock(c.QuietHours.Start); err != nil {
+			problems = append(problems, "quiet_hours: invalid start: "+err.Error())
+		}
+		if _, err := parseClock(c.QuietHours.End); err != nil {
+			problems = append(problems, "quiet_hours: invalid end: "+err.Error())
+		}
+		if _, err := time.LoadLocation(c.QuietHours.DefaultTimezone); err != nil {
+			problems = append(problems, "quiet_hours: invalid default_timezone: "+err.Error())
+		}
+	}
+	if c.DedupWindowSeconds < 0 {
+		problems = append(problems, "dedup_window_seconds must not be negative")
+	}
+	return problems
+}
+
+// sendPolicy hold state of rate limits and dedup, it is rebuilt when config
+// is reloaded
+type sendPolicy struct {
+	config PolicyConfig
+	now    func() time.Time
+	// quiet hours parsed once, locations are cached by timezone name (nil
+	// for invalid ones so they are logged once)
+	quietStart      int
+	quietEnd        int
+	defaultLocation *time.Location
+	locations       Sync.Map
+
+	mutex   Sync.Mutex
+	buckets map[string]*tokenBucket
+	seen    map[string]time.Time
+	calls   int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newSendPolicy(config *PolicyConfig) *sendPolicy {
+	if config == nil {
+		return nil
+	}
+	policy := &sendPolicy{
+		config:          *config,
+		now:             time.Now,
+		defaultLocation: time.UTC,
+		buckets:         map[string]*tokenBucket{},
+		seen:            map[string]time.Time{},
+	}
+	if quiet := config.QuietHours; quiet != nil {
+		policy.quietStart, _ = parseClock(quiet.Start)
+		policy.quietEnd, _ = parseClock(quiet.End)
+		if location, err := time.LoadLocation(quiet.DefaultTimezone); err == nil {
+			policy.defaultLocation = location
+		}
+	}
+	return policy
+}
+
+// location return cached location of timezone, default location when
+// timezone is empty or invalid
+func (p *sendPolicy) location(timezone string) *time.Location {
+	if timezone == "" {
+		return p.defaultLocation
+	}
+	if cached, found := p.locations.Load(timezone); found {
+		if location := cached.(*time.Location); location != nil {
+			return location
+		}
+		return p.defaultLocation
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		getLogger().Warn("notification: invalid timezone", "timezone", timezone, "default_timezone", p.config.QuietHours.DefaultTimezone)
+		p.locations.Store(timezone, (*time.Location)(nil))
+		return p.defaultLocation
+	}
+	p.locations.Store(timezone, location)
+	return location
+}
+
+// tokenOwner is what policies know about the owner of a token
+type tokenOwner struct {
+	userID   string
+	timezone string
+}
+
+// check decide for one token, it return skip reason or empty when token
+// can be sent, and the end of quiet hours for SkipQuietHours. Allowed
+// tokens consume rate limit and are remembered for dedup.
+func (p *sendPolicy) check(clientKey string, token string, owner tokenOwner, msg *Message, fingerprint string) (string, time.Time) {
+	now := p.now()
+	if p.config.QuietHours != nil && !msg.IgnoreQuietHours {
+		if end, quiet := p.quietUntil(owner.timezone, now); quiet {
+			return SkipQuietHours, end
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls++
+	if p.calls%1000 == 0 {
+		p.prune(now)
+	}
+	dedupKey := ""
+	if p.config.DedupWindowSeconds > 0 {
+		dedupKey = clientKey + "|" + token + "|" + fingerprint
+		if expire, found := p.seen[dedupKey]; found && expire.After(now) {
+			return SkipDuplicate, time.Time{}
+		}
+	}
+	limits := map[string]*RateLimit{"app:" + clientKey: p.config.AppRateLimit, "token:" + token: p.config.TokenRateLimit}
+	if owner.userID != "" {
+		limits["user:"+owner.userID] = p.config.UserRateLimit
+	}
+	// take from every bucket or from none of them
+	var take []*tokenBucket
+	for key, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		bucket := p.bucket(key, limit, now)
+		if bucket.tokens < 1 {
+			return SkipRateLimited, time.Time{}
+		}
+		take = append(take, bucket)
+	}
+	for _, bucket := range take {
+		bucket.tokens--
+	}
+	if dedupKey != "" {
+		p.seen[dedupKey] = now.Add(time.Duration(p.config.DedupWindowSeconds) * time.Second)
+	}
+	return "", time.Time{}
+}
+
+// forget remove dedup record of a token which could not be sent so it can
+// be sent again
+func (p *sendPolicy) forget(clientKey string, token string, fingerprint string) {
+	if p.config.DedupWindowSeconds <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.seen, clientKey+"|"+token+"|"+fingerprint)
+}
+
+// bucket return bucket of key refilled until now
+func (p *sendPolicy) bucket(key string, limit *RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = float64(limit.Count)
+	}
+	bucket, found := p.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		p.buckets[key] = bucket
+		return bucket
+	}
+	rate := float64(limit.Count) / float64(limit.PeriodSeconds)
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+	return bucket
+}
+
+// prune drop expired dedup records and buckets idle for a day
+func (p *sendPolicy) prune(now time.Time) {
+	for key, expire := range p.seen {
+		if !expire.After(now) {
+			delete(p.seen, key)
+		}
+	}
+	for key, bucket := range p.buckets {
+		if now.Sub(bucket.last) > 24*time.Hour {
+			delete(p.buckets, key)
+		}
+	}
+}
+
+// quietUntil return end of quiet hours when now is inside them in timezone
+func (p *sendPolicy) quietUntil(timezone string, now time.Time) (time.Time, bool) {
+	location := p.location(timezone)
+	start, end := p.quietStart, p.quietEnd
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	inside := false
+	if start <= end {
+		inside = minute >= start && minute < end
+	} else {
+		inside = minute >= start || minute < end
+	}
+	if !inside {
+		return time.Time{}, false
+	}
+	until := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, location)
+	if !until.After(now) {
+		until = time.Date(local.Year(), local.Month(), local.Day()+1, end/60, end%60, 0, 0, location)
+	}
+	return until, true
+}
+
+// parseClock parse "hh:mm" to minutes of day
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%q is not hh:mm", value)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("%q is not hh:mm", value)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("%q is not hh:mm", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// messageFingerprint identify message content for dedup
+func messageFingerprint(msg *Message) string {
+	raw, _ := json.Marshal(struct {
+		Title, Body, Topic, Condition string
+		Data                          interface{}
+	}{msg.Title, msg.Body, msg.Topic, msg.Condition, msg.PayloadData})
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
+
+// SetPolicy replace send policies, nil disable them. Rate limit and dedup
+// state start empty.
+func (a *AppNotification) SetPolicy(config *PolicyConfig) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.policy = newSendPolicy(config)
+	a.config.Policy = config
+}
+
+// SetTokenOwnerResolver register resolver used by policies to find user and
+// timezone of tokens
+func (a *AppNotification) SetTokenOwnerResolver(resolver TokenOwnerResolver) {
+	a.ownerResolver = resolver
+}
+
+// SetDeferOutbox make tokens in quiet hours be enqueued to outbox for the end
+// of quiet hours instead of being dropped
+func (a *AppNotification) SetDeferOutbox(outbox *Outbox) {
+	a.deferOutbox = outbox
+}
+
+// applyPolicy split msg.Tokens into tokens to send now and skipped results
+// (by index of msg.Tokens). Topic messages are not checked.
+func (a *AppNotification) applyPolicy(ctx context.Context, clientKey string, client *Client, msg *Message) (*Message, map[int]TokenResult) {
+	a.mutex.RLock()
+	policy := a.policy
+	a.mutex.RUnlock()
+	if policy == nil || msg.IsTopicMessage() {
+		return msg, nil
+	}
+
+	fingerprint := messageFingerprint(msg)
+	skipped := map[int]TokenResult{}
+	deferred := map[tokenOwner][]string{}
+	deferUntil := map[tokenOwner]time.Time{}
+	allowed := make([]string, 0, len(msg.Tokens))
+	for i, token := range msg.Tokens {
+		owner := a.tokenOwner(ctx, client.Platform, token, msg)
+		reason, until := policy.check(clientKey, token, owner, msg, fingerprint)
+		if reason == "" {
+			allowed = append(allowed, token)
+			continue
+		}
+		res := TokenResult{
+			Token:    token,
+			Platform: client.Platform,
+			Status:   StatusSkipped,
+			Reason:   reason,
+		}
+		if reason == SkipQuietHours {
+			res.RetryAfter = until.Sub(policy.now())
+			deferred[owner] = append(deferred[owner], token)
+			deferUntil[owner] = until
+		}
+		skipped[i] = res
+	}
+	if len(skipped) > 0 {
+		a.log().Info("notification: tokens skipped by policy", "platform", platformName(client.Platform), "client", clientKey, "tokens", len(skipped))
+	}
+	a.deferTokens(ctx, clientKey, client, msg, deferred, deferUntil)
+
+	allowedMsg := *msg
+	allowedMsg.Tokens = allowed
+	return &allowedMsg, skipped
+}
+
+// tokenOwner return user and timezone of token: from the recipient it came
+// from, the message, then the resolver
+func (a *AppNotification) tokenOwner(ctx context.Context, platform int, token string, msg *Message) tokenOwner {
+	owner := tokenOwner{userID: msg.UserID, timezone: msg.Timezone}
+	if recipient, found := msg.owners[token]; found {
+		if recipient.userID != "" {
+			owner.userID = recipient.userID
+		}
+		if recipient.timezone != "" {
+			owner.timezone = recipient.timezone
+		}
+	}
+	if (owner.userID == "" || owner.timezone == "") && a.ownerResolver != nil {
+		if userID, timezone, found := a.ownerResolver.TokenOwner(ctx, platform, token); found {
+			if owner.userID == "" {
+				owner.userID = userID
+			}
+			if owner.timezone == "" {
+				owner.timezone = timezone
+			}
+		}
+	}
+	return owner
+}
+
+// deferTokens enqueue tokens skipped by quiet hours to the defer outbox, one
+// job per owner so user and timezone are kept
+func (a *AppNotification) deferTokens(ctx context.Context, clientKey string, client *Client, msg *Message,
+	deferred map[tokenOwner][]string, deferUntil map[tokenOwner]time.Time) {
+	if a.deferOutbox == nil {
+		return
+	}
+	for owner, tokens := range deferred {
+		deferMsg := *msg
+		deferMsg.Tokens = tokens
+		deferMsg.Recipients = nil
+		deferMsg.owners = nil
+		deferMsg.UserID = owner.userID
+		deferMsg.Timezone = owner.timezone
+		_, err := a.deferOutbox.SendAt(ctx, deferUntil[owner], OutboxJob{
+			Platform:  client.Platform,
+			ClientKey: clientKey,
+			Message:   &deferMsg,
+		})
+		if err != nil {
+			a.log().Error("notification: defer tokens failed", "platform", platformName(client.Platform), "client", clientKey, "tokens", len(tokens), "error", err)
+		}
+	}
+}
+
+// forgetUnsent allow tokens which were not sent to be sent again within
+// dedup window
+func (a *AppNotification) forgetUnsent(clientKey string, msg *Message, report *DeliveryReport) {
+	a.mutex.RLock()
+	policy := a.policy
+	a.mutex.RUnlock()
+	if policy == nil || msg.IsTopicMessage() {
+		return
+	}
+	fingerprint := messageFingerprint(msg)
+	for _, res := range report.Results {
+		if !res.Sent() {
+			policy.forget(clientKey, res.Token, fingerprint)
+		}
+	}
+}
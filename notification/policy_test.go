@@ -0,0 +1,129 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAppNotification_Policy(t *testing.T) {
+	ctx := context.Background()
+	newApp := func(policy *PolicyConfig) (*AppNotification, *recordSender) {
+		sender := &recordSender{}
+		a, err := NewAppNotification(Config{Policy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = a.AddClient(FCMClientKey("app"), NewClient(PLATFORM_FCM, "app", sender))
+		return a, sender
+	}
+	statuses := func(report *DeliveryReport) []string {
+		var out []string
+		for _, res := range report.Results {
+			out = append(out, res.Token+":"+string(res.Status)+":"+res.Reason)
+		}
+		return out
+	}
+	assertStatuses := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("results = %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("results = %v, want %v", got, want)
+				return
+			}
+		}
+	}
+
+	// rate limit per user, b belongs to the same user as a
+	a, _ := newApp(&PolicyConfig{UserRateLimit: &RateLimit{Count: 1, PeriodSeconds: 3600}})
+	report := a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app"), &Message{Title: "1", UserID: "u1", Tokens: []string{"a", "b"}})
+	assertStatuses(statuses(report), "a:sent:", "b:skipped:RateLimited")
+	if report.SkippedCount() != 1 || report.FailureCount() != 0 {
+		t.Errorf("SkippedCount() = %d, FailureCount() = %d", report.SkippedCount(), report.FailureCount())
+	}
+
+	// dedup
+	a, sender := newApp(&PolicyConfig{DedupWindowSeconds: 60})
+	a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app"), &Message{Title: "hello", Tokens: []string{"a"}})
+	report = a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app"), &Message{Title: "hello", Tokens: []string{"a", "b"}})
+	assertStatuses(statuses(report), "a:skipped:Duplicate", "b:sent:")
+	report = a.SendMessageCtx(ctx, PLATFORM_FCM, FCMClientKey("app"), &Message{Title: "bye", Tokens: []string{"a"}})
+	assertStatuses(statuses(report), "a:sent:")
+	if len(sender.messages) != 3 {
+		t.Errorf("provider called %d times, want 3", len(sender.messages))
+	}
+
+	// quiet hours 22:00-07:00, at 23:00 UTC it is 08:00 in Tokyo
+	a, _ = newApp(&PolicyConfig{QuietHours: &QuietHours{Start: "22:00", End: "07:00"}})
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	a.policy.now = func() time.Time { return now }
+	store := NewMemoryOutboxStore()
+	outbox := NewOutbox(a, store, OutboxOptions{})
+	a.SetDeferOutbox(outbox)
+	report = a.SendToRecipients(ctx, &Message{
+		Title: "sale",
+		Recipients: []Recipient{
+			{Platform: PLATFORM_FCM, Address: "tokyo", Timezone: "Asia/Tokyo"},
+			{Platform: PLATFORM_FCM, Address: "utc"},
+		},
+	})
+	assertStatuses(statuses(report), "tokyo:sent:", "utc:skipped:QuietHours")
+	if got := report.Results[1].RetryAfter; got != 8*time.Hour {
+		t.Errorf("RetryAfter = %v, want 8h", got)
+	}
+	if early, _ := store.Claim(ctx, "test", now.Add(7*time.Hour), 10, time.Minute); len(early) != 0 {
+		t.Errorf("deferred job is due before end of quiet hours")
+	}
+	jobs, _ := store.Claim(ctx, "test", now.Add(8*time.Hour), 10, time.Minute)
+	if len(jobs) != 1 || jobs[0].Message.Tokens[0] != "utc" {
+		t.Fatalf("deferred jobs = %+v", jobs)
+	}
+}
+
+func TestSendPolicy_quietUntil(t *testing.T) {
+	out := &bytes.Buffer{}
+	SetLogger(slog.New(slog.NewTextHandler(out, nil)))
+	defer SetLogger(LogrusLogger(logrus.StandardLogger()))
+	policy := newSendPolicy(&PolicyConfig{QuietHours: &QuietHours{Start: "22:00", End: "07:00", DefaultTimezone: "Asia/Tokyo"}})
+	// 08:00 in Tokyo, 23:00 in UTC
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	if _, quiet := policy.quietUntil("", now); quiet {
+		t.Error("quiet hours in default timezone")
+	}
+	if until, quiet := policy.quietUntil("UTC", now); !quiet || !until.Equal(now.Add(8*time.Hour)) {
+		t.Errorf("quietUntil(UTC) = %v, %v", until, quiet)
+	}
+	// invalid timezone use the default one and is logged once
+	for i := 0; i < 3; i++ {
+		if _, quiet := policy.quietUntil("Mars/Base", now); quiet {
+			t.Error("quiet hours of invalid timezone")
+		}
+	}
+	if logs := strings.Count(out.String(), "invalid timezone"); logs != 1 {
+		t.Errorf("invalid timezone logged %d times, want 1", logs)
+	}
+}
+
+func TestPolicyConfig_validate(t *testing.T) {
+	config := &PolicyConfig{
+		UserRateLimit:  &RateLimit{Count: 1, PeriodSeconds: 60, Burst: -1},
+		TokenRateLimit: &RateLimit{Count: 0, PeriodSeconds: 60},
+	}
+	problems := config.validate()
+	want := []string{
+		"user_rate_limit: burst must not be negative",
+		"token_rate_limit: count and period_seconds must be positive",
+	}
+	if len(problems) != len(want) || problems[0] != want[0] || problems[1] != want[1] {
+		t.Errorf("validate() = %v, want %v", problems, want)
+	}
+}
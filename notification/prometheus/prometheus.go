@@ -0,0 +1,140 @@
+// Package prometheus collect notification metrics with the Prometheus client,
+// it is a separate package so notification does not depend on Prometheus
+package prometheus
+
+import (
+	"net/http"
+	"sort"
+	Sync "sync"
+
+	"github.com/praslar/lib/notification"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// latency buckets in seconds, same as Prometheus client default
+	defaultDurationBuckets = prom.DefBuckets
+	defaultBatchBuckets    = []float64{1, 10, 50, 100, 250, 500, 1000}
+)
+
+var metricHelp = map[string]string{
+	notification.MetricMessages:     "Token results of notification sends by status and reason.",
+	notification.MetricSendDuration: "Latency of notification provider calls in seconds.",
+	notification.MetricBatchSize:    "Number of tokens per notification provider call.",
+}
+
+// Metrics implement notification.Metrics as a prometheus.Collector, mount it
+// as the /metrics handler or register it in an existing registry with
+// prometheus.MustRegister(metrics)
+type Metrics struct {
+	mutex Sync.Mutex
+	// histogram buckets by metric name, metrics not in map use duration
+	// buckets
+	buckets map[string][]float64
+	// vectors are created on first sample, label names are the ones of
+	// that sample
+	counters   map[string]*prom.CounterVec
+	histograms map[string]*prom.HistogramVec
+	handler    http.Handler
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		buckets: map[string][]float64{
+			notification.MetricSendDuration: defaultDurationBuckets,
+			notification.MetricBatchSize:    defaultBatchBuckets,
+		},
+		counters:   map[string]*prom.CounterVec{},
+		histograms: map[string]*prom.HistogramVec{},
+	}
+	registry := prom.NewRegistry()
+	registry.MustRegister(m)
+	m.handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// SetBuckets change upper bounds of histogram buckets, it should be called
+// before sending, samples already observed for the metric are dropped
+func (m *Metrics) SetBuckets(name string, buckets []float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	m.buckets[name] = sorted
+	delete(m.histograms, name)
+}
+
+func (m *Metrics) AddCounter(name string, labels notification.Labels, value float64) {
+	m.mutex.Lock()
+	vec := m.counters[name]
+	if vec == nil {
+		vec = prom.NewCounterVec(prom.CounterOpts{Name: name, Help: helpOf(name)}, labelNames(labels))
+		m.counters[name] = vec
+	}
+	m.mutex.Unlock()
+	// labels which do not match the first sample are dropped
+	if counter, err := vec.GetMetricWith(prom.Labels(labels)); err == nil {
+		counter.Add(value)
+	}
+}
+
+func (m *Metrics) ObserveHistogram(name string, labels notification.Labels, value float64) {
+	m.mutex.Lock()
+	vec := m.histograms[name]
+	if vec == nil {
+		buckets, found := m.buckets[name]
+		if !found {
+			buckets = defaultDurationBuckets
+		}
+		vec = prom.NewHistogramVec(prom.HistogramOpts{Name: name, Help: helpOf(name), Buckets: buckets}, labelNames(labels))
+		m.histograms[name] = vec
+	}
+	m.mutex.Unlock()
+	if histogram, err := vec.GetMetricWith(prom.Labels(labels)); err == nil {
+		histogram.Observe(value)
+	}
+}
+
+// Describe send nothing, metrics are created on first sample so the
+// collector is registered as unchecked
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {}
+
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, collector := range m.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	collectors := make([]prom.Collector, 0, len(m.counters)+len(m.histograms))
+	for _, vec := range m.counters {
+		collectors = append(collectors, vec)
+	}
+	for _, vec := range m.histograms {
+		collectors = append(collectors, vec)
+	}
+	return collectors
+}
+
+func helpOf(name string) string {
+	if help, found := metricHelp[name]; found {
+		return help
+	}
+	return name
+}
+
+func labelNames(labels notification.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,71 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praslar/lib/notification"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	fake := notification.NewFakeBackend()
+	a, err := notification.NewAppNotification(notification.Config{AndroidConfig: &notification.FCMConfig{ID: "shop"}}, notification.WithFakeBackend(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	a.SetMetrics(metrics)
+
+	fake.SetInvalidToken("old")
+	a.SendMessageCtx(context.Background(), notification.PLATFORM_FCM, notification.FCMClientKey("shop"), &notification.Message{Tokens: []string{"a", "b", "old"}})
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE notification_messages_total counter",
+		`notification_messages_total{client="FCM_shop",platform="fcm",reason="",status="sent"} 2`,
+		`notification_messages_total{client="FCM_shop",platform="fcm",reason="Unregistered",status="invalid_token"} 1`,
+		"# TYPE notification_batch_size histogram",
+		`notification_batch_size_bucket{client="FCM_shop",platform="fcm",le="1"} 0`,
+		`notification_batch_size_bucket{client="FCM_shop",platform="fcm",le="10"} 1`,
+		`notification_batch_size_sum{client="FCM_shop",platform="fcm"} 3`,
+		`notification_send_duration_seconds_count{client="FCM_shop",platform="fcm"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics missing %q in\n%s", line, out)
+		}
+	}
+
+	// it is also a collector of an existing registry
+	if err := prom.NewRegistry().Register(metrics); err != nil {
+		t.Errorf("Register() error = %v", err)
+	}
+}
+
+func TestMetrics_SetBuckets(t *testing.T) {
+	metrics := NewMetrics()
+	labels := notification.Labels{"platform": "fcm", "client": "FCM_shop"}
+	metrics.ObserveHistogram(notification.MetricBatchSize, labels, 3)
+	metrics.SetBuckets(notification.MetricBatchSize, []float64{5, 2})
+	metrics.ObserveHistogram(notification.MetricBatchSize, labels, 3)
+	// labels which do not match the first sample are dropped
+	metrics.ObserveHistogram(notification.MetricBatchSize, notification.Labels{"platform": "fcm"}, 3)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := recorder.Body.String()
+	for _, line := range []string{
+		`notification_batch_size_bucket{client="FCM_shop",platform="fcm",le="2"} 0`,
+		`notification_batch_size_bucket{client="FCM_shop",platform="fcm",le="5"} 1`,
+		`notification_batch_size_count{client="FCM_shop",platform="fcm"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics missing %q in\n%s", line, out)
+		}
+	}
+}
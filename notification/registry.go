@@ -0,0 +1,263 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+//=================================================================
+// Client registry
+// - Many FCM / APNs apps per AppNotification (white-label apps)
+// - Add / remove / replace client at runtime
+// - Route message to an app or a tenant
+//=================================================================
+var ErrClientExists = errors.New("notification: client already exists")
+
+func FCMClientKey(id string) string {
+	return "FCM_" + id
+}
+
+func APNsClientKey(id string) string {
+	return "APNs_" + id
+}
+
+func WebPushClientKey(id string) string {
+	return "WebPush_" + id
+}
+
+func EmailClientKey(id string) string {
+	return "Email_" + id
+}
+
+func SMSClientKey(id string) string {
+	return "SMS_" + id
+}
+
+// NewClient create client of platform sending through sender, retry and
+// topic are not set up, use it to register custom senders
+func NewClient(platform int, appID string, sender Sender) *Client {
+	topics, _ := sender.(TopicManager)
+	return &Client{
+		Platform: platform,
+		AppID:    appID,
+		sender:   sender,
+		topics:   topics,
+	}
+}
+
+// NewFCMClient create FCM client from config
+func NewFCMClient(config *FCMConfig, retry *RetryPolicy) (*Client, error) {
+	sender, err := FCMSenderFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(PLATFORM_FCM, config.ID, WithRetry(sender, retry))
+	client.topics, _ = sender.(TopicManager)
+	client.Tenant = config.Tenant
+	client.SenderID = config.ClientID
+	return client, nil
+}
+
+// NewAPNsClient create APNs client from config
+func NewAPNsClient(config *APNsConfig, retry *RetryPolicy) (*Client, error) {
+	iOSClient, err := APNsClientFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(PLATFORM_APNs, config.ID, WithRetry(NewAPNsSender(iOSClient, config), retry))
+	client.Tenant = config.Tenant
+	client.AppBundleID = config.AppBundleID
+	return client, nil
+}
+
+// NewWebPushClient create web push client from config
+func NewWebPushClient(config *WebPushConfig, retry *RetryPolicy) (*Client, error) {
+	webClient, err := WebPushClientFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(PLATFORM_WebPush, config.ID, WithRetry(webClient, retry))
+	client.Tenant = config.Tenant
+	return client, nil
+}
+
+// NewEmailClient create SMTP email client from config
+func NewEmailClient(config *EmailConfig, retry *RetryPolicy) (*Client, error) {
+	emailClient, err := EmailClientFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(PLATFORM_Email, config.ID, WithRetry(emailClient, retry))
+	client.Tenant = config.Tenant
+	return client, nil
+}
+
+// NewSMSClient create SMS gateway client from config
+func NewSMSClient(config *SMSConfig, retry *RetryPolicy) (*Client, error) {
+	smsClient, err := SMSClientFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(PLATFORM_SMS, config.ID, WithRetry(smsClient, retry))
+	client.Tenant = config.Tenant
+	return client, nil
+}
+
+func clientOrEmpty(client *Client, err error) *Client {
+	if err != nil {
+		getLogger().Error("notification: init client failed", "error", err)
+		return &Client{}
+	}
+	if client == nil {
+		return &Client{}
+	}
+	return client
+}
+
+// fcmConfigs return AndroidConfig and AndroidConfigs
+func (c Config) fcmConfigs() []*FCMConfig {
+	var configs []*FCMConfig
+	if c.AndroidConfig != nil {
+		configs = append(configs, c.AndroidConfig)
+	}
+	for _, config := range c.AndroidConfigs {
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// apnsConfigs return IOSConfig and IOSConfigs
+func (c Config) apnsConfigs() []*APNsConfig {
+	var configs []*APNsConfig
+	if c.IOSConfig != nil {
+		configs = append(configs, c.IOSConfig)
+	}
+	for _, config := range c.IOSConfigs {
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// webConfigs return WebConfig and WebConfigs
+func (c Config) webConfigs() []*WebPushConfig {
+	var configs []*WebPushConfig
+	if c.WebConfig != nil {
+		configs = append(configs, c.WebConfig)
+	}
+	for _, config := range c.WebConfigs {
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// emailConfigs return EmailConfig and EmailConfigs
+func (c Config) emailConfigs() []*EmailConfig {
+	var configs []*EmailConfig
+	if c.EmailConfig != nil {
+		configs = append(configs, c.EmailConfig)
+	}
+	for _, config := range c.EmailConfigs {
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// smsConfigs return SMSConfig and SMSConfigs
+func (c Config) smsConfigs() []*SMSConfig {
+	var configs []*SMSConfig
+	if c.SMSConfig != nil {
+		configs = append(configs, c.SMSConfig)
+	}
+	for _, config := range c.SMSConfigs {
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// Client return client registered with key
+func (a *AppNotification) Client(key string) (*Client, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	client, found := a.clients[key]
+	return client, found
+}
+
+// ClientKeys return sorted keys of registered clients
+func (a *AppNotification) ClientKeys() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	keys := make([]string, 0, len(a.clients))
+	for key := range a.clients {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AddClient register client with key, it fail if key is already used
+func (a *AppNotification) AddClient(key string, client *Client) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, found := a.clients[key]; found {
+		return ErrClientExists
+	}
+	a.clients[key] = client
+	return nil
+}
+
+// ReplaceClient register client with key and return the previous one, sends
+// already started keep using the previous client
+func (a *AppNotification) ReplaceClient(key string, client *Client) *Client {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	old := a.clients[key]
+	a.clients[key] = client
+	return old
+}
+
+// RemoveClient unregister client with key, return false if not found
+func (a *AppNotification) RemoveClient(key string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, found := a.clients[key]; !found {
+		return false
+	}
+	delete(a.clients, key)
+	return true
+}
+
+// clientList return a copy of clients so callers do not hold the lock while sending
+func (a *AppNotification) clientList() map[string]*Client {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	clients := make(map[string]*Client, len(a.clients))
+	for key, client := range a.clients {
+		clients[key] = client
+	}
+	return clients
+}
+
+// SendToApp send msg to every push client (FCM, APNs and Web) of app
+func (a *AppNotification) SendToApp(ctx context.Context, appID string, msg *Message) *DeliveryReport {
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return client.AppID == appID && isPushPlatform(client.Platform)
+	})
+}
+
+// SendToTenant send msg to every push client of tenant
+func (a *AppNotification) SendToTenant(ctx context.Context, tenant string, msg *Message) *DeliveryReport {
+	return a.sendMessageFor(ctx, msg, func(client *Client) bool {
+		return client.Tenant == tenant && isPushPlatform(client.Platform)
+	})
+}
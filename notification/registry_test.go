@@ -0,0 +1,91 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	Sync "sync"
+	"testing"
+)
+
+func TestAppNotification_Registry(t *testing.T) {
+	fake := NewFakeBackend()
+	a, _ := NewAppNotification(Config{})
+	shop := NewClient(PLATFORM_FCM, "shop", fake.Sender(PLATFORM_FCM, "shop"))
+	shop.Tenant = "acme"
+	if err := a.AddClient(FCMClientKey("shop"), shop); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddClient(FCMClientKey("shop"), shop); err != ErrClientExists {
+		t.Errorf("AddClient() error = %v, want %v", err, ErrClientExists)
+	}
+	_ = a.AddClient(APNsClientKey("driver"), NewClient(PLATFORM_APNs, "driver", fake.Sender(PLATFORM_APNs, "driver")))
+
+	report := a.SendToApp(context.Background(), "driver", &Message{Tokens: []string{"a"}})
+	if len(report.Results) != 1 || report.Results[0].ClientKey != APNsClientKey("driver") {
+		t.Errorf("SendToApp() results = %+v", report.Results)
+	}
+	report = a.SendToTenant(context.Background(), "acme", &Message{Tokens: []string{"a"}})
+	if len(report.Results) != 1 || report.Results[0].ClientKey != FCMClientKey("shop") {
+		t.Errorf("SendToTenant() results = %+v", report.Results)
+	}
+
+	replacement := NewClient(PLATFORM_FCM, "shop", fake.Sender(PLATFORM_FCM, "shop"))
+	if old := a.ReplaceClient(FCMClientKey("shop"), replacement); old != shop {
+		t.Errorf("ReplaceClient() = %v, want previous client", old)
+	}
+	if client, _ := a.Client(FCMClientKey("shop")); client != replacement {
+		t.Errorf("Client() = %v, want replacement", client)
+	}
+	if !a.RemoveClient(FCMClientKey("shop")) || a.RemoveClient(FCMClientKey("shop")) {
+		t.Error("RemoveClient() must remove the client once")
+	}
+	if keys := a.ClientKeys(); len(keys) != 1 || keys[0] != APNsClientKey("driver") {
+		t.Errorf("ClientKeys() = %v", keys)
+	}
+}
+
+// run with -race, clients are changed while messages are sent
+func TestAppNotification_RegistryConcurrent(t *testing.T) {
+	fake := NewFakeBackend()
+	a, _ := NewAppNotification(Config{})
+	newClient := func(i int) *Client {
+		id := fmt.Sprintf("app%d", i)
+		return NewClient(PLATFORM_FCM, id, fake.Sender(PLATFORM_FCM, id))
+	}
+	for i := 0; i < 4; i++ {
+		_ = a.AddClient(FCMClientKey(fmt.Sprintf("app%d", i)), newClient(i))
+	}
+
+	wg := &Sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				report := a.SendMessageForAllCtx(context.Background(), &Message{Tokens: []string{"a", "b"}})
+				for _, res := range report.Results {
+					if res.Status != StatusSent {
+						t.Errorf("result = %+v", res)
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			key := FCMClientKey(fmt.Sprintf("app%d", i%4))
+			switch i % 3 {
+			case 0:
+				a.ReplaceClient(key, newClient(i%4))
+			case 1:
+				a.RemoveClient(key)
+			case 2:
+				_ = a.AddClient(key, newClient(i%4))
+			}
+			_ = a.ClientKeys()
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,226 @@
+package notification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+)
+
+//=================================================================
+// Hot reload
+// - Reload clients when config or key files change
+// - Keep serving with old clients if new config is invalid
+//=================================================================
+const defaultWatchInterval = 30 * time.Second
+
+// ConfigLoader load the latest config, ex: read file or fetch from vault
+type ConfigLoader func() (Config, error)
+
+// FileConfigLoader load json config from path
+func FileConfigLoader(path string) ConfigLoader {
+	return func() (Config, error) {
+		config := Config{}
+		rawData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		err = json.Unmarshal(rawData, &config)
+		return config, err
+	}
+}
+
+// buildClients validate config and create every client of it, it fail if
+// any client can not be initialized. When newSender is set (see
+// WithSenderFactory) clients send through it and config is not validated.
+func buildClients(config Config, newSender SenderFactory) (map[string]*Client, error) {
+	if newSender != nil {
+		return buildFactoryClients(config, newSender), nil
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	clients := map[string]*Client{}
+	for _, fcmConfig := range config.fcmConfigs() {
+		client, err := NewFCMClient(fcmConfig, config.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("notification: init FCM client %s: %w", fcmConfig.ID, err)
+		}
+		clients[FCMClientKey(fcmConfig.ID)] = client
+	}
+	for _, apnsConfig := range config.apnsConfigs() {
+		client, err := NewAPNsClient(apnsConfig, config.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("notification: init APNs client %s: %w", apnsConfig.ID, err)
+		}
+		clients[APNsClientKey(apnsConfig.ID)] = client
+	}
+	for _, webConfig := range config.webConfigs() {
+		client, err := NewWebPushClient(webConfig, config.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("notification: init WebPush client %s: %w", webConfig.ID, err)
+		}
+		clients[WebPushClientKey(webConfig.ID)] = client
+	}
+	for _, emailConfig := range config.emailConfigs() {
+		client, err := NewEmailClient(emailConfig, config.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("notification: init Email client %s: %w", emailConfig.ID, err)
+		}
+		clients[EmailClientKey(emailConfig.ID)] = client
+	}
+	for _, smsConfig := range config.smsConfigs() {
+		client, err := NewSMSClient(smsConfig, config.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("notification: init SMS client %s: %w", smsConfig.ID, err)
+		}
+		clients[SMSClientKey(smsConfig.ID)] = client
+	}
+	return clients, nil
+}
+
+// buildFactoryClients create a client of every config sending through
+// newSender, only IDs and routing fields of configs are used
+func buildFactoryClients(config Config, newSender SenderFactory) map[string]*Client {
+	clients := map[string]*Client{}
+	newClient := func(platform int, id, tenant string) *Client {
+		client := NewClient(platform, id, WithRetry(newSender(platform, id), config.Retry))
+		client.Tenant = tenant
+		return client
+	}
+	for _, fcmConfig := range config.fcmConfigs() {
+		client := newClient(PLATFORM_FCM, fcmConfig.ID, fcmConfig.Tenant)
+		client.SenderID = fcmConfig.ClientID
+		clients[FCMClientKey(fcmConfig.ID)] = client
+	}
+	for _, apnsConfig := range config.apnsConfigs() {
+		client := newClient(PLATFORM_APNs, apnsConfig.ID, apnsConfig.Tenant)
+		client.AppBundleID = apnsConfig.AppBundleID
+		clients[APNsClientKey(apnsConfig.ID)] = client
+	}
+	for _, webConfig := range config.webConfigs() {
+		clients[WebPushClientKey(webConfig.ID)] = newClient(PLATFORM_WebPush, webConfig.ID, webConfig.Tenant)
+	}
+	for _, emailConfig := range config.emailConfigs() {
+		clients[EmailClientKey(emailConfig.ID)] = newClient(PLATFORM_Email, emailConfig.ID, emailConfig.Tenant)
+	}
+	for _, smsConfig := range config.smsConfigs() {
+		clients[SMSClientKey(smsConfig.ID)] = newClient(PLATFORM_SMS, smsConfig.ID, smsConfig.Tenant)
+	}
+	return clients
+}
+
+// configKeys return client keys created from config
+func configKeys(config Config) map[string]bool {
+	keys := map[string]bool{}
+	for _, fcmConfig := range config.fcmConfigs() {
+		keys[FCMClientKey(fcmConfig.ID)] = true
+	}
+	for _, apnsConfig := range config.apnsConfigs() {
+		keys[APNsClientKey(apnsConfig.ID)] = true
+	}
+	for _, webConfig := range config.webConfigs() {
+		keys[WebPushClientKey(webConfig.ID)] = true
+	}
+	for _, emailConfig := range config.emailConfigs() {
+		keys[EmailClientKey(emailConfig.ID)] = true
+	}
+	for _, smsConfig := range config.smsConfigs() {
+		keys[SMSClientKey(smsConfig.ID)] = true
+	}
+	return keys
+}
+
+// ReloadConfig rebuild clients from config and swap them in at once. If any
+// client can not be created the current clients are kept and error is
+// returned. Clients registered with AddClient are not touched.
+func (a *AppNotification) ReloadConfig(config Config) error {
+	clients, err := buildClients(config, a.newSender)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for key := range configKeys(a.config) {
+		delete(a.clients, key)
+	}
+	for key, client := range clients {
+		a.clients[key] = client
+	}
+	// keep rate limit and dedup state when policy did not change
+	if !reflect.DeepEqual(a.config.Policy, config.Policy) {
+		a.policy = newSendPolicy(config.Policy)
+	}
+	a.config = config
+	return nil
+}
+
+// WatchConfig check loader every interval and reload clients when config or
+// key files referenced by config change, it stop when ctx is done
+func (a *AppNotification) WatchConfig(ctx context.Context, loader ConfigLoader, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	a.mutex.RLock()
+	lastFingerprint := fingerprintConfig(a.config)
+	a.mutex.RUnlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			config, err := loader()
+			if err != nil {
+				a.log().Error("notification: load config failed", "error", err)
+				continue
+			}
+			fingerprint := fingerprintConfig(config)
+			if fingerprint == lastFingerprint {
+				continue
+			}
+			// remember it even on error so a broken config is reported once
+			lastFingerprint = fingerprint
+			if err := a.ReloadConfig(config); err != nil {
+				a.log().Error("notification: reload config failed, keep old clients", "error", err)
+				continue
+			}
+			a.log().Info("notification: config reloaded")
+		}
+	}()
+}
+
+// fingerprintConfig hash config, APNs key bytes (not in json) and content of
+// key files it reference, so rotating a key in place is detected
+func fingerprintConfig(config Config) string {
+	hash := sha256.New()
+	rawConfig, _ := json.Marshal(config)
+	hash.Write(rawConfig)
+	var paths []string
+	for _, fcmConfig := range config.fcmConfigs() {
+		paths = append(paths, fcmConfig.ServiceAccountFile)
+	}
+	for _, apnsConfig := range config.apnsConfigs() {
+		paths = append(paths, apnsConfig.KeyFilePath)
+		keyHash := sha256.Sum256(apnsConfig.KeyBytes)
+		hash.Write(keyHash[:])
+	}
+	for _, emailConfig := range config.emailConfigs() {
+		paths = append(paths, emailConfig.TextTemplateFile, emailConfig.HTMLTemplateFile)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		data, _ := ioutil.ReadFile(path)
+		hash.Write(data)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
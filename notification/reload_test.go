@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	Sync "sync"
+	"testing"
+	"time"
+)
+
+func TestAppNotification_ReloadConfig(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	account := testServiceAccount(t, server.URL)
+	a, err := NewAppNotification(Config{AndroidConfig: &FCMConfig{ID: "shop", ServiceAccountData: account}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := NewClient(PLATFORM_FCM, "custom", NewFakeBackend().Sender(PLATFORM_FCM, "custom"))
+	_ = a.AddClient(FCMClientKey("custom"), custom)
+	assertKeys := func(name string, want ...string) {
+		t.Helper()
+		keys := a.ClientKeys()
+		if len(keys) != len(want) {
+			t.Fatalf("%s: ClientKeys() = %v, want %v", name, keys, want)
+		}
+		for i := range want {
+			if keys[i] != want[i] {
+				t.Fatalf("%s: ClientKeys() = %v, want %v", name, keys, want)
+			}
+		}
+	}
+
+	err = a.ReloadConfig(Config{AndroidConfigs: []*FCMConfig{{ID: "driver", ServiceAccountData: account}}})
+	if err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	assertKeys("reload", "FCM_custom", "FCM_driver")
+
+	// invalid config keep the current clients
+	err = a.ReloadConfig(Config{AndroidConfigs: []*FCMConfig{{ID: "driver", ServiceAccountData: account}, {ID: "broken"}}})
+	if err == nil {
+		t.Fatal("ReloadConfig() of invalid config error = nil")
+	}
+	assertKeys("invalid reload", "FCM_custom", "FCM_driver")
+	if client, _ := a.Client(FCMClientKey("custom")); client != custom {
+		t.Error("ReloadConfig() replaced client added with AddClient")
+	}
+}
+
+func TestAppNotification_WatchConfig(t *testing.T) {
+	var (
+		mutex  Sync.Mutex
+		config = Config{AndroidConfig: &FCMConfig{ID: "shop"}}
+	)
+	setConfig := func(c Config) {
+		mutex.Lock()
+		config = c
+		mutex.Unlock()
+	}
+	loader := func() (Config, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return config, nil
+	}
+	a, _ := NewAppNotification(config, WithFakeBackend(NewFakeBackend()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.WatchConfig(ctx, loader, 5*time.Millisecond)
+
+	waitKeys := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if keys := a.ClientKeys(); len(keys) == 1 && keys[0] == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("ClientKeys() = %v, want [%s]", a.ClientKeys(), want)
+	}
+	setConfig(Config{AndroidConfig: &FCMConfig{ID: "driver"}})
+	waitKeys("FCM_driver")
+	setConfig(Config{IOSConfig: &APNsConfig{ID: "driver"}})
+	waitKeys("APNs_driver")
+}
+
+func TestFingerprintConfig(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key.p8")
+	_ = ioutil.WriteFile(keyPath, []byte("key 1"), 0600)
+	config := Config{
+		IOSConfig:  &APNsConfig{ID: "shop", KeyFilePath: keyPath},
+		IOSConfigs: []*APNsConfig{{ID: "driver", KeyBytes: []byte("key 1")}},
+	}
+	before := fingerprintConfig(config)
+	if fingerprintConfig(config) != before {
+		t.Fatal("fingerprint of the same config changed")
+	}
+
+	// key bytes are not in json
+	config.IOSConfigs[0].KeyBytes = []byte("key 2")
+	rotated := fingerprintConfig(config)
+	if rotated == before {
+		t.Error("fingerprint did not change when KeyBytes changed")
+	}
+	_ = ioutil.WriteFile(keyPath, []byte("key 2"), 0600)
+	if fingerprintConfig(config) == rotated {
+		t.Error("fingerprint did not change when key file changed")
+	}
+}
@@ -0,0 +1,137 @@
+package notification
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+// RetryPolicy control how transient failures (APNs 429/500/503, FCM 5xx,
+// UNAVAILABLE...) are retried. Only tokens which failed with a retryable
+// result are sent again.
+type RetryPolicy struct {
+	// total attempts per token include the first one, 0 or 1 disable retry
+	MaxAttempts int `json:"max_attempts"`
+	// delay before the first retry, it is doubled after each attempt
+	BaseDelayMs int `json:"base_delay_ms"`
+	MaxDelayMs  int `json:"max_delay_ms"`
+	// fraction of delay added randomly (0..1) to spread retries
+	Jitter float64 `json:"jitter"`
+	// do not wait for Retry-After returned by provider. A Retry-After longer
+	// than MaxDelayMs stop retrying, result keep RetryAfter so caller (ex:
+	// Outbox) can send later.
+	IgnoreRetryAfter bool `json:"ignore_retry_after"`
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelayMs > 0 {
+		return time.Duration(p.MaxDelayMs) * time.Millisecond
+	}
+	return defaultRetryMaxDelay
+}
+
+// backoff return the delay before the given retry (1 is the first retry),
+// retryAfter is never waited longer than max delay
+func (p *RetryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	base, max := defaultRetryBaseDelay, p.maxDelay()
+	if p.BaseDelayMs > 0 {
+		base = time.Duration(p.BaseDelayMs) * time.Millisecond
+	}
+	if retryAfter > max {
+		retryAfter = max
+	}
+	delay := base
+	for i := 1; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	if !p.IgnoreRetryAfter && retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+type retrySender struct {
+	next   Sender
+	policy RetryPolicy
+}
+
+// WithRetry wrap sender so retryable token failures are sent again following
+// policy, a nil policy only record Attempts
+func WithRetry(sender Sender, policy *RetryPolicy) Sender {
+	s := &retrySender{next: sender}
+	if policy != nil {
+		s.policy = *policy
+	}
+	return s
+}
+
+func (s *retrySender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	report, err := s.next.Send(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range report.Results {
+		report.Results[i].Attempts = 1
+	}
+
+	for attempt := 2; attempt <= s.policy.MaxAttempts; attempt++ {
+		// collect tokens to retry and the longest delay asked by provider
+		var pending []int
+		var tokens []string
+		var retryAfter time.Duration
+		for i, res := range report.Results {
+			if res.Status != StatusFailed || !res.Retryable {
+				continue
+			}
+			pending = append(pending, i)
+			tokens = append(tokens, res.Token)
+			if res.RetryAfter > retryAfter {
+				retryAfter = res.RetryAfter
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if !s.policy.IgnoreRetryAfter && retryAfter > s.policy.maxDelay() {
+			// do not block the sender for hours, let caller schedule it
+			break
+		}
+
+		timer := time.NewTimer(s.policy.backoff(attempt-1, retryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return report, nil
+		case <-timer.C:
+		}
+
+		retryMsg := *msg
+		if !msg.IsTopicMessage() {
+			retryMsg.Tokens = tokens
+		}
+		retryReport, err := s.next.Send(ctx, &retryMsg)
+		if err != nil {
+			break
+		}
+		for j, i := range pending {
+			if j >= len(retryReport.Results) {
+				break
+			}
+			res := retryReport.Results[j]
+			res.Attempts = attempt
+			report.Results[i] = res
+		}
+	}
+	return report, nil
+}
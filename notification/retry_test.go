@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	policy := &RetryPolicy{BaseDelayMs: 100, MaxDelayMs: 1000}
+	tests := []struct {
+		retry      int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{1, 0, 100 * time.Millisecond},
+		{2, 0, 200 * time.Millisecond},
+		{3, 0, 400 * time.Millisecond},
+		{4, 0, 800 * time.Millisecond},
+		{5, 0, time.Second},
+		{10, 0, time.Second},
+		// provider delay win when longer, but never more than max
+		{1, 500 * time.Millisecond, 500 * time.Millisecond},
+		{4, 500 * time.Millisecond, 800 * time.Millisecond},
+		{1, time.Hour, time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.retry, tt.retryAfter); got != tt.want {
+			t.Errorf("backoff(%d, %v) = %v, want %v", tt.retry, tt.retryAfter, got, tt.want)
+		}
+	}
+
+	ignore := &RetryPolicy{BaseDelayMs: 100, IgnoreRetryAfter: true}
+	if got := ignore.backoff(1, time.Second); got != 100*time.Millisecond {
+		t.Errorf("backoff() ignoring Retry-After = %v", got)
+	}
+	defaults := &RetryPolicy{}
+	if got := defaults.backoff(1, 0); got != defaultRetryBaseDelay {
+		t.Errorf("default backoff(1) = %v, want %v", got, defaultRetryBaseDelay)
+	}
+	if got := defaults.backoff(20, 0); got != defaultRetryMaxDelay {
+		t.Errorf("default backoff(20) = %v, want %v", got, defaultRetryMaxDelay)
+	}
+
+	jitter := &RetryPolicy{BaseDelayMs: 100, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := jitter.backoff(1, 0); got < 100*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff() with jitter = %v, want 100ms..150ms", got)
+		}
+	}
+}
+
+func TestWithRetry_RetryAfter(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	var calls int32
+	var retryAfter atomic.Value
+	fcmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", retryAfter.Load().(string))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":{"code":503,"message":"unavailable","status":"UNAVAILABLE"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+	}))
+	defer fcmServer.Close()
+	client, err := NewFCMV1Client(&FCMConfig{
+		ServiceAccountData: testServiceAccount(t, tokenServer.URL),
+		BaseURL:            fcmServer.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := WithRetry(client, &RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1, MaxDelayMs: 2000})
+	msg := &Message{Title: "title", Tokens: []string{"token"}}
+
+	// Retry-After within max delay is waited then the token is sent again
+	retryAfter.Store("1")
+	start := time.Now()
+	report, err := sender.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := report.Results[0]; res.Status != StatusSent || res.Attempts != 2 {
+		t.Errorf("result = %+v, want sent at attempt 2", res)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want Retry-After 1s", elapsed)
+	}
+
+	// Retry-After longer than max delay fail fast and keep the delay
+	atomic.StoreInt32(&calls, 0)
+	retryAfter.Store("3600")
+	start = time.Now()
+	report, _ = sender.Send(context.Background(), msg)
+	res := report.Results[0]
+	if res.Status != StatusFailed || res.Attempts != 1 || !res.Retryable || res.RetryAfter != time.Hour {
+		t.Errorf("result = %+v, want retryable failure after 1 attempt", res)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send() blocked %v", elapsed)
+	}
+}
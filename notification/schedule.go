@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//=================================================================
+// Scheduled delivery
+// - A scheduled message is an outbox job which is not due yet, so it
+//   is persisted by the outbox store and survive restarts
+// - Jobs due while no worker was running are sent when workers start
+//=================================================================
+
+// SendAt enqueue job to be sent at time at
+func (o *Outbox) SendAt(ctx context.Context, at time.Time, job OutboxJob) (*OutboxJob, error) {
+	job.NextAttemptAt = at
+	return o.Enqueue(ctx, job)
+}
+
+// SendAfter enqueue job to be sent after delay, ex: remind in 2 hours
+func (o *Outbox) SendAfter(ctx context.Context, delay time.Duration, job OutboxJob) (*OutboxJob, error) {
+	return o.SendAt(ctx, o.now().Add(delay), job)
+}
+
+// SendAtLocalTime enqueue job to be sent at the next hour:minute in
+// timezone, ex: 9:00 in "Asia/Ho_Chi_Minh"
+func (o *Outbox) SendAtLocalTime(ctx context.Context, timezone string, hour int, minute int, job OutboxJob) (*OutboxJob, error) {
+	at, err := NextLocalTime(timezone, hour, minute, o.now())
+	if err != nil {
+		return nil, err
+	}
+	return o.SendAt(ctx, at, job)
+}
+
+// Cancel cancel a job which is not sent yet, it return
+// ErrOutboxJobNotCancelable when the job is already sent or being sent
+func (o *Outbox) Cancel(ctx context.Context, id string) error {
+	return o.store.Cancel(ctx, id, o.now())
+}
+
+// Job return job with id, use it to check status of a scheduled job
+func (o *Outbox) Job(ctx context.Context, id string) (*OutboxJob, error) {
+	return o.store.Get(ctx, id)
+}
+
+// NextLocalTime return the first time after now when clock of timezone
+// ("Asia/Ho_Chi_Minh", empty is UTC) show hour:minute. On a DST gap the
+// time is moved forward like time.Date does.
+func NextLocalTime(timezone string, hour int, minute int, now time.Time) (time.Time, error) {
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return time.Time{}, fmt.Errorf("notification: invalid local time %02d:%02d", hour, minute)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("notification: invalid timezone %q: %w", timezone, err)
+	}
+	local := now.In(location)
+	at := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, location)
+	if !at.After(now) {
+		at = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, location)
+	}
+	return at, nil
+}
@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextLocalTime(t *testing.T) {
+	// 2024-03-10 08:30 UTC is 15:30 in Ho Chi Minh and 04:30 in New York
+	// (DST start day)
+	now := time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		timezone     string
+		hour, minute int
+		want         string
+	}{
+		{"Asia/Ho_Chi_Minh", 9, 0, "2024-03-11T09:00:00+07:00"},
+		{"Asia/Ho_Chi_Minh", 16, 0, "2024-03-10T16:00:00+07:00"},
+		{"America/New_York", 9, 0, "2024-03-10T09:00:00-04:00"},
+		{"", 8, 30, "2024-03-11T08:30:00Z"},
+	}
+	for _, tt := range tests {
+		got, err := NextLocalTime(tt.timezone, tt.hour, tt.minute, now)
+		if err != nil {
+			t.Fatalf("NextLocalTime(%s) error = %v", tt.timezone, err)
+		}
+		if got.Format(time.RFC3339) != tt.want {
+			t.Errorf("NextLocalTime(%s, %d:%d) = %s, want %s", tt.timezone, tt.hour, tt.minute, got.Format(time.RFC3339), tt.want)
+		}
+	}
+	if _, err := NextLocalTime("Mars/Base", 9, 0, now); err == nil {
+		t.Error("NextLocalTime() with unknown timezone error = nil")
+	}
+}
+
+func TestOutbox_SendAfter(t *testing.T) {
+	sender := &recordSender{}
+	a, _ := NewAppNotification(Config{})
+	_ = a.AddClient(FCMClientKey("app"), NewClient(PLATFORM_FCM, "app", sender))
+	outbox := NewOutbox(a, NewMemoryOutboxStore(), OutboxOptions{})
+	now := time.Now()
+	outbox.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	reminder, _ := outbox.SendAfter(ctx, 2*time.Hour, OutboxJob{Message: &Message{Title: "reminder", Tokens: []string{"a"}}})
+	canceled, _ := outbox.SendAfter(ctx, 2*time.Hour, OutboxJob{Message: &Message{Title: "canceled", Tokens: []string{"a"}}})
+	if err := outbox.Cancel(ctx, canceled.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if n, _ := outbox.ProcessOnce(ctx); n != 0 {
+		t.Fatalf("ProcessOnce() claimed %d jobs before they are due", n)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if n, _ := outbox.ProcessOnce(ctx); n != 1 {
+		t.Fatalf("ProcessOnce() claimed %d jobs, want 1", n)
+	}
+	if len(sender.messages) != 1 || sender.messages[0].Title != "reminder" {
+		t.Errorf("sent %+v", sender.messages)
+	}
+	if job, _ := outbox.Job(ctx, reminder.ID); job.Status != OutboxDone {
+		t.Errorf("reminder status = %s", job.Status)
+	}
+	if err := outbox.Cancel(ctx, reminder.ID); !errors.Is(err, ErrOutboxJobNotCancelable) {
+		t.Errorf("Cancel() of sent job error = %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package notification
+
+import (
+	"context"
+	"time"
+)
+
+// Sender deliver a message through one push provider (FCM, APNs...) and
+// report the outcome of every token in msg.Tokens. Results must be in the
+// same order as msg.Tokens.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) (*DeliveryReport, error)
+}
+
+type DeliveryStatus string
+
+const (
+	StatusSent         DeliveryStatus = "sent"
+	StatusFailed       DeliveryStatus = "failed"
+	StatusInvalidToken DeliveryStatus = "invalid_token"
+	// not sent, Reason tell why: a send policy or a token which is not
+	// one of the client (ex: FCM token sent to a web push client)
+	StatusSkipped DeliveryStatus = "skipped"
+)
+
+// TokenResult is delivery result of one device token, for topic message
+// Token is the topic or condition
+type TokenResult struct {
+	Token     string
+	Platform  int
+	ClientKey string
+	Status    DeliveryStatus
+	// http status code and reason code returned by provider
+	StatusCode int
+	Reason     string
+	// apns-id for APNs, message_id for FCM
+	MessageID string
+	// retry hint, RetryAfter is zero when provider did not ask for a delay
+	Retryable  bool
+	RetryAfter time.Duration
+	// number of times the token was sent, see RetryPolicy
+	Attempts int
+	Err      error
+}
+
+func (r TokenResult) Sent() bool {
+	return r.Status == StatusSent
+}
+
+// DeliveryReport collect results of one send, Results keep order of msg.Tokens
+// for each client
+type DeliveryReport struct {
+	Results []TokenResult
+}
+
+func (r *DeliveryReport) add(results ...TokenResult) {
+	r.Results = append(r.Results, results...)
+}
+
+func (r *DeliveryReport) merge(other *DeliveryReport) {
+	if other == nil {
+		return
+	}
+	r.add(other.Results...)
+}
+
+func (r *DeliveryReport) SuccessCount() int {
+	count := 0
+	for _, res := range r.Results {
+		if res.Sent() {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *DeliveryReport) FailureCount() int {
+	return len(r.Failed())
+}
+
+func (r *DeliveryReport) SkippedCount() int {
+	return len(r.Results) - r.SuccessCount() - r.FailureCount()
+}
+
+// Failed return results of tokens which could not be delivered, skipped
+// tokens are not included
+func (r *DeliveryReport) Failed() []TokenResult {
+	var failed []TokenResult
+	for _, res := range r.Results {
+		if !res.Sent() && res.Status != StatusSkipped {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// withSkipped put skipped results back to their index in msg.Tokens, report
+// hold results of the other tokens in order
+func withSkipped(report *DeliveryReport, skipped map[int]TokenResult, total int) *DeliveryReport {
+	if len(skipped) == 0 {
+		return report
+	}
+	results := make([]TokenResult, 0, total)
+	next := 0
+	for i := 0; i < total; i++ {
+		if res, found := skipped[i]; found {
+			results = append(results, res)
+			continue
+		}
+		if next < len(report.Results) {
+			results = append(results, report.Results[next])
+			next++
+		}
+	}
+	return &DeliveryReport{Results: results}
+}
+
+// failAll mark every token of msg as failed with the same error
+func failAll(platform int, msg *Message, err error, retryable bool) *DeliveryReport {
+	report := &DeliveryReport{}
+	tokens := msg.Tokens
+	if msg.IsTopicMessage() {
+		tokens = []string{msg.topicTarget()}
+	}
+	for _, token := range tokens {
+		report.add(TokenResult{
+			Token:     token,
+			Platform:  platform,
+			Status:    StatusFailed,
+			Retryable: retryable,
+			Err:       err,
+		})
+	}
+	return report
+}
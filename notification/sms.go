@@ -0,0 +1,256 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//=================================================================
+// SMS channel - generic HTTP gateway
+// - One request per phone number, body is rendered from template
+// - Most gateways (Twilio, Vonage, local providers) can be configured
+// - Token is the phone number, E.164 is recommended
+//=================================================================
+const (
+	defaultSMSConcurrency = 10
+	defaultSMSText        = "{{if .Title}}{{.Title}}: {{end}}{{.Body}}"
+	defaultSMSContentType = "application/json"
+)
+
+type SMSConfig struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	// gateway endpoint, default method is POST
+	URL    string `json:"url"`
+	Method string `json:"method"`
+	// extra headers, ex: {"Authorization": "Bearer ..."}
+	Headers map[string]string `json:"headers"`
+	// basic auth, used when username is set
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	// sms text, rendered with ChannelTemplateData, default "Title: Body"
+	TextTemplate string `json:"text_template"`
+	// request body, rendered with SMSRequestData, default
+	// {"from": From, "to": To, "text": Text}. Use {{json .Text}} in json
+	// body and {{urlquery .Text}} in form body to escape values
+	BodyTemplate string `json:"body_template"`
+	// default application/json
+	ContentType string `json:"content_type"`
+	// field of json response holding message id, ex: "sid", "data.id"
+	MessageIDField string `json:"message_id_field"`
+	// response status codes meaning phone number is invalid, ex: [400]
+	InvalidStatusCodes []int `json:"invalid_status_codes"`
+	// number of concurrent requests, default 10
+	Concurrency int `json:"concurrency"`
+}
+
+// SMSRequestData is data of SMSConfig.BodyTemplate
+type SMSRequestData struct {
+	From string
+	To   string
+	Text string
+}
+
+type SMSClient struct {
+	URL                string
+	Method             string
+	Headers            map[string]string
+	Username           string
+	Password           string
+	From               string
+	ContentType        string
+	MessageIDField     string
+	InvalidStatusCodes []int
+	Concurrency        int
+
+	text       *template.Template
+	body       *template.Template
+	httpClient *http.Client
+}
+
+// SMSClientFromConfig create HTTP gateway client and parse templates of config
+func SMSClientFromConfig(config *SMSConfig) (*SMSClient, error) {
+	if _, err := url.ParseRequestURI(config.URL); err != nil {
+		return nil, fmt.Errorf("platform SMS: invalid url: %w", err)
+	}
+	client := &SMSClient{
+		URL:                config.URL,
+		Method:             strings.ToUpper(config.Method),
+		Headers:            config.Headers,
+		Username:           config.Username,
+		Password:           config.Password,
+		From:               config.From,
+		ContentType:        config.ContentType,
+		MessageIDField:     config.MessageIDField,
+		InvalidStatusCodes: config.InvalidStatusCodes,
+		Concurrency:        config.Concurrency,
+		httpClient:         &http.Client{Timeout: 30 * time.Second},
+	}
+	if client.Method == "" {
+		client.Method = http.MethodPost
+	}
+	if client.ContentType == "" {
+		client.ContentType = defaultSMSContentType
+	}
+	if client.Concurrency <= 0 {
+		client.Concurrency = defaultSMSConcurrency
+	}
+
+	var err error
+	if client.text, err = parseChannelTemplate("text", config.TextTemplate, defaultSMSText); err != nil {
+		return nil, fmt.Errorf("platform SMS: text_template: %w", err)
+	}
+	if strings.TrimSpace(config.BodyTemplate) != "" {
+		client.body, err = template.New("body").Option("missingkey=zero").Funcs(template.FuncMap{
+			"json": func(value interface{}) (string, error) {
+				raw, err := json.Marshal(value)
+				return string(raw), err
+			},
+		}).Parse(config.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("platform SMS: body_template: %w", err)
+		}
+	}
+	return client, nil
+}
+
+func (c *SMSClient) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	results := sendEach(msg.Tokens, c.Concurrency, func(to string) TokenResult {
+		return c.sendOne(ctx, to, msg)
+	})
+	return &DeliveryReport{Results: results}, nil
+}
+
+func (c *SMSClient) sendOne(ctx context.Context, to string, msg *Message) TokenResult {
+	result := TokenResult{
+		Token:    to,
+		Platform: PLATFORM_SMS,
+	}
+	if strings.TrimSpace(to) == "" {
+		result.Status = StatusInvalidToken
+		result.Reason = "InvalidPhoneNumber"
+		return result
+	}
+	body, err := c.requestBody(to, msg)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Reason = "InvalidTemplate"
+		result.Err = err
+		return result
+	}
+
+	req, err := http.NewRequest(c.Method, c.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+		return result
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", c.ContentType)
+	for key, value := range c.Headers {
+		req.Header.Set(key, value)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Retryable = ctx.Err() == nil
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	result.StatusCode = resp.StatusCode
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		result.Status = StatusSent
+		result.MessageID = jsonField(respBody, c.MessageIDField)
+	case c.isInvalidStatus(resp.StatusCode):
+		result.Status = StatusInvalidToken
+		result.Reason = http.StatusText(resp.StatusCode)
+	default:
+		result.Status = StatusFailed
+		result.Reason = http.StatusText(resp.StatusCode)
+		result.Retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		result.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		result.Err = errors.New(strings.TrimSpace(string(respBody)))
+	}
+	return result
+}
+
+// requestBody render sms text then the gateway request body
+func (c *SMSClient) requestBody(to string, msg *Message) ([]byte, error) {
+	text := &bytes.Buffer{}
+	if err := c.text.Execute(text, channelTemplateData(msg, to)); err != nil {
+		return nil, err
+	}
+	data := SMSRequestData{
+		From: c.From,
+		To:   to,
+		Text: strings.TrimSpace(text.String()),
+	}
+	if c.body == nil {
+		return json.Marshal(map[string]string{
+			"from": data.From,
+			"to":   data.To,
+			"text": data.Text,
+		})
+	}
+	body := &bytes.Buffer{}
+	if err := c.body.Execute(body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+func (c *SMSClient) isInvalidStatus(statusCode int) bool {
+	for _, code := range c.InvalidStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonField return value at dotted path of json object as string, empty
+// when path is empty or not found
+func jsonField(raw []byte, path string) string {
+	if path == "" {
+		return ""
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// keep numeric ids as they are, not float
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
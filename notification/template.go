@@ -0,0 +1,373 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	Sync "sync"
+	"text/template"
+)
+
+//=================================================================
+// Message templates
+// - Title and body are go text/template, one per name and locale
+// - Locale fall back from "pt-BR" to "pt" then to default locales
+// - {{plural .Count "one" "# item" "other" "# items"}} use plural rule of locale
+//=================================================================
+var ErrTemplateNotFound = errors.New("notification: template not found")
+
+// MessageTemplate is title and body template of one locale
+type MessageTemplate struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// PluralRule return plural category ("zero", "one", "two", "few", "many" or
+// "other") of n, see CLDR plural rules
+type PluralRule func(n int64) string
+
+type TemplateRegistry struct {
+	// locales tried when template has no locale of recipient, in order
+	DefaultLocales []string
+
+	mutex       Sync.RWMutex
+	templates   map[string]map[string]*parsedTemplate
+	pluralRules map[string]PluralRule
+}
+
+type parsedTemplate struct {
+	locale string
+	title  *template.Template
+	body   *template.Template
+}
+
+// NewTemplateRegistry create empty registry, defaultLocales is the last
+// fallback of every locale, ex: "en"
+func NewTemplateRegistry(defaultLocales ...string) *TemplateRegistry {
+	locales := make([]string, 0, len(defaultLocales))
+	for _, locale := range defaultLocales {
+		locales = append(locales, normalizeLocale(locale))
+	}
+	return &TemplateRegistry{
+		DefaultLocales: locales,
+		templates:      map[string]map[string]*parsedTemplate{},
+		pluralRules:    map[string]PluralRule{},
+	}
+}
+
+// SetPluralRule set plural rule of language ("pl", "ar"...), languages
+// without rule use builtin rules or English rule
+func (r *TemplateRegistry) SetPluralRule(language string, rule PluralRule) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pluralRules[normalizeLocale(language)] = rule
+}
+
+// Register parse template of name for locale, it replace the existing one
+func (r *TemplateRegistry) Register(name string, locale string, tmpl MessageTemplate) error {
+	locale = normalizeLocale(locale)
+	parsed := &parsedTemplate{locale: locale}
+	funcs := template.FuncMap{"plural": r.pluralFunc(locale)}
+	var err error
+	if parsed.title, err = template.New(name + ".title").Option("missingkey=error").Funcs(funcs).Parse(tmpl.Title); err != nil {
+		return fmt.Errorf("notification: template %s[%s] title: %w", name, locale, err)
+	}
+	if parsed.body, err = template.New(name + ".body").Option("missingkey=error").Funcs(funcs).Parse(tmpl.Body); err != nil {
+		return fmt.Errorf("notification: template %s[%s] body: %w", name, locale, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.templates[name] == nil {
+		r.templates[name] = map[string]*parsedTemplate{}
+	}
+	r.templates[name][locale] = parsed
+	return nil
+}
+
+// LoadFile register templates of json file, format is
+// {"order_shipped": {"en": {"title": "...", "body": "..."}, "vi": {...}}}
+func (r *TemplateRegistry) LoadFile(path string) error {
+	rawData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	templates := map[string]map[string]MessageTemplate{}
+	if err := json.Unmarshal(rawData, &templates); err != nil {
+		return fmt.Errorf("notification: template file %s: %w", path, err)
+	}
+	for name, locales := range templates {
+		for locale, tmpl := range locales {
+			if err := r.Register(name, locale, tmpl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Render render template name in locale, or the first fallback locale it
+// has, and return message with Title and Body set
+func (r *TemplateRegistry) Render(name string, locale string, data interface{}) (*Message, error) {
+	parsed, err := r.lookup(name, locale)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.render(data)
+}
+
+// lookup return template of name in the first locale of fallback chain
+func (r *TemplateRegistry) lookup(name string, locale string) (*parsedTemplate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, candidate := range r.localeChain(locale) {
+		if parsed, found := r.templates[name][candidate]; found {
+			return parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s[%s]", ErrTemplateNotFound, name, locale)
+}
+
+// localeChain return "pt-br", "pt" then default locales
+func (r *TemplateRegistry) localeChain(locale string) []string {
+	var chain []string
+	locale = normalizeLocale(locale)
+	for locale != "" {
+		chain = append(chain, locale)
+		cut := strings.LastIndex(locale, "-")
+		if cut < 0 {
+			break
+		}
+		locale = locale[:cut]
+	}
+	return append(chain, r.DefaultLocales...)
+}
+
+func (p *parsedTemplate) render(data interface{}) (*Message, error) {
+	title := &bytes.Buffer{}
+	if err := p.title.Execute(title, data); err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	if err := p.body.Execute(body, data); err != nil {
+		return nil, err
+	}
+	return &Message{
+		Title: title.String(),
+		Body:  body.String(),
+	}, nil
+}
+
+// pluralFunc return "plural" template func of locale, forms are pairs of
+// category and text, "#" in text is replaced by count. Missing category
+// fall back to "other".
+func (r *TemplateRegistry) pluralFunc(locale string) func(count interface{}, forms ...string) (string, error) {
+	return func(count interface{}, forms ...string) (string, error) {
+		if len(forms)%2 != 0 {
+			return "", errors.New("plural: forms must be pairs of category and text")
+		}
+		n, err := pluralCount(count)
+		if err != nil {
+			return "", err
+		}
+		category := r.pluralRule(locale)(n)
+		text, other := "", ""
+		for i := 0; i < len(forms); i += 2 {
+			switch forms[i] {
+			case category:
+				text = forms[i+1]
+			case "other":
+				other = forms[i+1]
+			}
+		}
+		if text == "" {
+			text = other
+		}
+		return strings.Replace(text, "#", strconv.FormatInt(n, 10), -1), nil
+	}
+}
+
+// pluralRule return rule of locale language, rules are read at render time
+// so SetPluralRule after Register still apply
+func (r *TemplateRegistry) pluralRule(locale string) PluralRule {
+	language := strings.SplitN(locale, "-", 2)[0]
+	r.mutex.RLock()
+	rule, found := r.pluralRules[language]
+	r.mutex.RUnlock()
+	if found {
+		return rule
+	}
+	if rule, found := builtinPluralRules[language]; found {
+		return rule
+	}
+	return pluralOneOther
+}
+
+func pluralCount(count interface{}) (int64, error) {
+	switch n := count.(type) {
+	case int:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("plural: count %v is not a number", count)
+}
+
+// pluralOneOther is rule of English and most west european languages
+func pluralOneOther(n int64) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// pluralSlavic is rule of Russian and Ukrainian
+func pluralSlavic(n int64) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return "one"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "few"
+	}
+	return "many"
+}
+
+var builtinPluralRules = map[string]PluralRule{
+	"fr": func(n int64) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"ru": pluralSlavic,
+	"uk": pluralSlavic,
+	"pl": func(n int64) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		}
+		return "many"
+	},
+	"cs": func(n int64) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+		return "other"
+	},
+	// no plural form
+	"vi": func(int64) string { return "other" },
+	"ja": func(int64) string { return "other" },
+	"ko": func(int64) string { return "other" },
+	"zh": func(int64) string { return "other" },
+	"th": func(int64) string { return "other" },
+	"id": func(int64) string { return "other" },
+}
+
+// normalizeLocale turn "pt_BR" into "pt-br"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// SetTemplates register template registry used by RenderAndSend
+func (a *AppNotification) SetTemplates(templates *TemplateRegistry) {
+	a.templates = templates
+}
+
+// RenderAndSend render template name for every recipient of msg.Recipients
+// in the language of its device (Recipient.Locale, locale when empty) and
+// send one message per rendered locale through SendToRecipients. Each
+// message is a copy of msg (payload data, sound, platform options...) with
+// the rendered title and body. Recipients whose template can not be
+// rendered are reported as failed.
+func (a *AppNotification) RenderAndSend(ctx context.Context, name string, locale string, data interface{}, msg *Message) *DeliveryReport {
+	recipients := msg.Recipients
+	report := &DeliveryReport{}
+	if a.templates == nil {
+		report.merge(failRecipients(recipients, ErrTemplateNotFound))
+		return report
+	}
+
+	// group by the locale of template actually used, so "pt-BR" and "pt-PT"
+	// falling back to "pt" are sent together
+	var locales []string
+	groups := map[string][]Recipient{}
+	templates := map[string]*parsedTemplate{}
+	for _, recipient := range recipients {
+		recipientLocale := recipient.Locale
+		if recipientLocale == "" {
+			recipientLocale = locale
+		}
+		parsed, err := a.templates.lookup(name, recipientLocale)
+		if err != nil {
+			report.merge(failRecipients([]Recipient{recipient}, err))
+			continue
+		}
+		if _, found := groups[parsed.locale]; !found {
+			locales = append(locales, parsed.locale)
+			templates[parsed.locale] = parsed
+		}
+		groups[parsed.locale] = append(groups[parsed.locale], recipient)
+	}
+
+	reports := make([]*DeliveryReport, len(locales))
+	wg := &Sync.WaitGroup{}
+	for i, groupLocale := range locales {
+		rendered, err := templates[groupLocale].render(data)
+		if err != nil {
+			reports[i] = failRecipients(groups[groupLocale], err)
+			continue
+		}
+		groupMsg := *msg
+		groupMsg.Title = rendered.Title
+		groupMsg.Body = rendered.Body
+		groupMsg.Recipients = groups[groupLocale]
+		wg.Add(1)
+		go func(i int, groupMsg *Message) {
+			defer wg.Done()
+			reports[i] = a.SendToRecipients(ctx, groupMsg)
+		}(i, &groupMsg)
+	}
+	wg.Wait()
+	for _, res := range reports {
+		report.merge(res)
+	}
+	return report
+}
+
+// failRecipients report every recipient as failed with err
+func failRecipients(recipients []Recipient, err error) *DeliveryReport {
+	report := &DeliveryReport{}
+	for _, recipient := range recipients {
+		report.add(TokenResult{
+			Token:    recipient.Address,
+			Platform: recipient.Platform,
+			Status:   StatusFailed,
+			Err:      err,
+		})
+	}
+	return report
+}
@@ -0,0 +1,118 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	Sync "sync"
+	"testing"
+)
+
+// recordSender record messages and report every token as sent
+type recordSender struct {
+	mutex    Sync.Mutex
+	messages []*Message
+}
+
+func (s *recordSender) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	s.mutex.Lock()
+	s.messages = append(s.messages, msg)
+	s.mutex.Unlock()
+	report := &DeliveryReport{}
+	for _, token := range msg.Tokens {
+		report.add(TokenResult{Token: token, Platform: PLATFORM_FCM, Status: StatusSent})
+	}
+	return report, nil
+}
+
+func TestTemplateRegistry_Render(t *testing.T) {
+	templates := NewTemplateRegistry("en")
+	for locale, tmpl := range map[string]MessageTemplate{
+		"en": {Title: "Order shipped", Body: `{{plural .Count "one" "# item is" "other" "# items are"}} on the way`},
+		"ru": {Title: "Заказ отправлен", Body: `{{plural .Count "one" "# товар" "few" "# товара" "many" "# товаров"}}`},
+		"vi": {Title: "Đã giao hàng", Body: `{{.Count}} sản phẩm đang được giao`},
+	} {
+		if err := templates.Register("order_shipped", locale, tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		locale string
+		count  int
+		want   string
+	}{
+		{"en", 1, "1 item is on the way"},
+		{"en-US", 3, "3 items are on the way"},
+		{"ru_RU", 21, "21 товар"},
+		{"ru", 3, "3 товара"},
+		{"ru", 11, "11 товаров"},
+		{"vi-VN", 2, "2 sản phẩm đang được giao"},
+		// no template, fall back to default locale
+		{"de", 2, "2 items are on the way"},
+	}
+	for _, tt := range tests {
+		msg, err := templates.Render("order_shipped", tt.locale, map[string]interface{}{"Count": tt.count})
+		if err != nil {
+			t.Fatalf("Render(%s) error = %v", tt.locale, err)
+		}
+		if msg.Body != tt.want {
+			t.Errorf("Render(%s, %d) = %q, want %q", tt.locale, tt.count, msg.Body, tt.want)
+		}
+	}
+	if _, err := templates.Render("missing", "en", nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Render(missing) error = %v", err)
+	}
+	if _, err := templates.Render("order_shipped", "en", map[string]interface{}{}); err == nil {
+		t.Error("Render() without Count error = nil")
+	}
+}
+
+func TestAppNotification_RenderAndSend(t *testing.T) {
+	templates := NewTemplateRegistry("en")
+	_ = templates.Register("welcome", "en", MessageTemplate{Title: "Hi {{.Name}}"})
+	_ = templates.Register("welcome", "pt", MessageTemplate{Title: "Olá {{.Name}}"})
+
+	sender := &recordSender{}
+	a, _ := NewAppNotification(Config{})
+	_ = a.AddClient(FCMClientKey("app"), NewClient(PLATFORM_FCM, "app", sender))
+	a.SetTemplates(templates)
+
+	base := &Message{
+		Title:       "ignored",
+		Sound:       "bell.caf",
+		PayloadData: map[string]interface{}{"screen": "home"},
+		Android:     &AndroidOptions{ChannelID: "welcome"},
+		Recipients: []Recipient{
+			{Platform: PLATFORM_FCM, Address: "a", Locale: "pt-BR"},
+			{Platform: PLATFORM_FCM, Address: "b"},
+			{Platform: PLATFORM_FCM, Address: "c", Locale: "pt-PT"},
+			{Platform: PLATFORM_FCM, Address: "d", Locale: "fr"},
+		},
+	}
+	report := a.RenderAndSend(context.Background(), "welcome", "en", map[string]string{"Name": "Ana"}, base)
+	if report.SuccessCount() != 4 {
+		t.Fatalf("Results = %+v", report.Results)
+	}
+	titles := map[string]string{}
+	for _, msg := range sender.messages {
+		for _, token := range msg.Tokens {
+			titles[token] = msg.Title
+		}
+		// everything but title and body comes from base message
+		if data, _ := msg.PayloadData.(map[string]interface{}); msg.Sound != "bell.caf" || data["screen"] != "home" || msg.Android == nil || msg.Android.ChannelID != "welcome" {
+			t.Errorf("message = %+v", msg)
+		}
+	}
+	if base.Title != "ignored" || len(base.Recipients) != 4 {
+		t.Errorf("base message changed = %+v", base)
+	}
+	want := map[string]string{"a": "Olá Ana", "b": "Hi Ana", "c": "Olá Ana", "d": "Hi Ana"}
+	for token, title := range want {
+		if titles[token] != title {
+			t.Errorf("title of %s = %q, want %q", token, titles[token], title)
+		}
+	}
+	if len(sender.messages) != 2 {
+		t.Errorf("sent %d messages, want one per locale", len(sender.messages))
+	}
+}
@@ -0,0 +1,194 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+//=================================================================
+// FCM topic
+// - Send to topic / condition
+// - Subscribe / unsubscribe device tokens through Instance ID API
+//=================================================================
+const (
+	FCMIIDBaseURL = "https://iid.googleapis.com"
+	// max tokens Instance ID API accept in one batchAdd/batchRemove
+	fcmTopicBatchSize = 1000
+)
+
+var ErrTopicNotSupported = errors.New("notification: client does not support topic subscription")
+
+// Instance ID error codes which mean the token will never work again,
+// INVALID_ARGUMENT is also returned for a bad topic name so it is a failure
+var fcmTopicInvalidTokenReasons = map[string]bool{
+	"NOT_FOUND": true,
+}
+
+// TopicManager is implemented by senders which can manage topic subscription
+// of device tokens
+type TopicManager interface {
+	Subscribe(ctx context.Context, topic string, tokens []string) (*DeliveryReport, error)
+	Unsubscribe(ctx context.Context, topic string, tokens []string) (*DeliveryReport, error)
+}
+
+// SendToTopic send msg to every FCM client subscriber of topic
+func (a *AppNotification) SendToTopic(ctx context.Context, topic string, msg *Message) *DeliveryReport {
+	topicMsg := *msg
+	topicMsg.Tokens = nil
+	topicMsg.Topic = topic
+	topicMsg.Condition = ""
+	return a.SendMessageForAndroidCtx(ctx, &topicMsg)
+}
+
+// SendToCondition send msg to every FCM client device matched by condition,
+// ex: "'stock' in topics && ('news' in topics || 'sport' in topics)"
+func (a *AppNotification) SendToCondition(ctx context.Context, condition string, msg *Message) *DeliveryReport {
+	topicMsg := *msg
+	topicMsg.Tokens = nil
+	topicMsg.Topic = ""
+	topicMsg.Condition = condition
+	return a.SendMessageForAndroidCtx(ctx, &topicMsg)
+}
+
+// SubscribeToTopic subscribe tokens of client to topic
+func (a *AppNotification) SubscribeToTopic(ctx context.Context, clientID string, topic string, tokens []string) *DeliveryReport {
+	return a.manageTopic(ctx, clientID, tokens, func(topics TopicManager) (*DeliveryReport, error) {
+		return topics.Subscribe(ctx, topic, tokens)
+	})
+}
+
+// UnsubscribeFromTopic unsubscribe tokens of client from topic
+func (a *AppNotification) UnsubscribeFromTopic(ctx context.Context, clientID string, topic string, tokens []string) *DeliveryReport {
+	return a.manageTopic(ctx, clientID, tokens, func(topics TopicManager) (*DeliveryReport, error) {
+		return topics.Unsubscribe(ctx, topic, tokens)
+	})
+}
+
+func (a *AppNotification) manageTopic(ctx context.Context, clientID string, tokens []string,
+	manage func(topics TopicManager) (*DeliveryReport, error)) *DeliveryReport {
+	msg := &Message{Tokens: tokens}
+	client, found := a.Client(clientID)
+	if !found {
+		return failAll(PLATFORM_FCM, msg, ErrClientNotFound, false)
+	}
+	if client.topics == nil {
+		return failAll(client.Platform, msg, ErrTopicNotSupported, false)
+	}
+	report, err := manage(client.topics)
+	if err != nil {
+		report = failAll(client.Platform, msg, err, false)
+	}
+	for i := range report.Results {
+		report.Results[i].ClientKey = clientID
+	}
+	a.invalidateTokens(report)
+	return report
+}
+
+type fcmTopicRequest struct {
+	To                 string   `json:"to"`
+	RegistrationTokens []string `json:"registration_tokens"`
+}
+
+type fcmTopicResponse struct {
+	Results []struct {
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+func (c *FCMV1Client) Subscribe(ctx context.Context, topic string, tokens []string) (*DeliveryReport, error) {
+	return c.manageTopic(ctx, "batchAdd", topic, tokens)
+}
+
+func (c *FCMV1Client) Unsubscribe(ctx context.Context, topic string, tokens []string) (*DeliveryReport, error) {
+	return c.manageTopic(ctx, "batchRemove", topic, tokens)
+}
+
+func (c *FCMV1Client) manageTopic(ctx context.Context, action string, topic string, tokens []string) (*DeliveryReport, error) {
+	if topic == "" {
+		return nil, errors.New("notification: topic was empty")
+	}
+	report := &DeliveryReport{}
+	for _, batch := range splitTokens(tokens, fcmTopicBatchSize) {
+		if len(batch) == 0 {
+			continue
+		}
+		results, err := c.manageTopicBatch(ctx, action, topic, batch)
+		if err != nil {
+			// request did not reach Instance ID API
+			report.merge(failAll(PLATFORM_FCM, &Message{Tokens: batch}, err, true))
+			continue
+		}
+		report.add(results...)
+	}
+	return report, nil
+}
+
+func (c *FCMV1Client) manageTopicBatch(ctx context.Context, action string, topic string, tokens []string) ([]TokenResult, error) {
+	body, err := json.Marshal(fcmTopicRequest{
+		To:                 "/topics/" + strings.TrimPrefix(topic, "/topics/"),
+		RegistrationTokens: tokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/iid/v1:%s", c.IIDBaseURL, action)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	// Instance ID API accept OAuth2 access token only with this header
+	req.Header.Set("access_token_auth", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	topicResp := fcmTopicResponse{}
+	_ = json.Unmarshal(respBody, &topicResp)
+	var batchErr error
+	reason := topicResp.Error
+	if resp.StatusCode != http.StatusOK {
+		batchErr = fmt.Errorf("notification: %s topic %s failed with status %d %s", action, topic, resp.StatusCode, topicResp.Error)
+		if reason == "" {
+			reason = http.StatusText(resp.StatusCode)
+		}
+	}
+
+	results := make([]TokenResult, len(tokens))
+	for i, token := range tokens {
+		res := TokenResult{
+			Token:      token,
+			Platform:   PLATFORM_FCM,
+			StatusCode: resp.StatusCode,
+			Status:     StatusSent,
+		}
+		if batchErr != nil {
+			// bad topic name or credentials (4xx) will not work on retry
+			res.Status = StatusFailed
+			res.Reason = reason
+			res.Err = batchErr
+			res.Retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+			res.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		} else if i < len(topicResp.Results) && topicResp.Results[i].Error != "" {
+			res.Reason = topicResp.Results[i].Error
+			res.Status = StatusFailed
+			if fcmTopicInvalidTokenReasons[res.Reason] {
+				res.Status = StatusInvalidToken
+			}
+		}
+		results[i] = res
+	}
+	return results, nil
+}
@@ -0,0 +1,201 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	Sync "sync"
+	"testing"
+
+	"github.com/NaySoftware/go-fcm"
+)
+
+func TestFCMSender_prepare(t *testing.T) {
+	s := NewFCMSender(fcm.NewFcmClient("key")).(*fcmSender)
+	s.prepare(&Message{Condition: "'a' in topics"}, nil)
+	if s.client.Message.Condition != "'a' in topics" {
+		t.Fatalf("condition message = %+v", s.client.Message)
+	}
+	s.prepare(&Message{Topic: "news"}, nil)
+	if s.client.Message.To != "/topics/news" || s.client.Message.Condition != "" {
+		t.Errorf("topic message = %+v", s.client.Message)
+	}
+
+	// token send after a topic send must not keep to or condition
+	s.prepare(&Message{Tokens: []string{"a", "b"}}, nil)
+	if message := s.client.Message; message.To != "" || message.Condition != "" || len(message.RegistrationIds) != 2 {
+		t.Errorf("token message = %+v", message)
+	}
+	s.prepare(&Message{Tokens: []string{"c"}}, nil)
+	if message := s.client.Message; len(message.RegistrationIds) != 1 || message.RegistrationIds[0] != "c" {
+		t.Errorf("registration ids = %v, want [c]", message.RegistrationIds)
+	}
+}
+
+// newTopicApp create app with a FCM v1 client whose send and Instance ID
+// requests go to handler
+func newTopicApp(t *testing.T, handler http.HandlerFunc) *AppNotification {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	a, err := NewAppNotification(Config{AndroidConfig: &FCMConfig{
+		ID:                 "shop",
+		ServiceAccountData: testServiceAccount(t, tokenServer.URL),
+		BaseURL:            server.URL,
+		IIDBaseURL:         server.URL,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAppNotification_SendToTopic(t *testing.T) {
+	var (
+		mutex    Sync.Mutex
+		requests []fcmV1Request
+	)
+	a := newTopicApp(t, func(w http.ResponseWriter, r *http.Request) {
+		req := fcmV1Request{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mutex.Lock()
+		requests = append(requests, req)
+		mutex.Unlock()
+		_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+	})
+
+	report := a.SendToTopic(context.Background(), "/topics/news", &Message{Title: "title", Tokens: []string{"ignored"}, Category: "NEWS"})
+	if len(report.Results) != 1 || report.Results[0].Token != "/topics/news" || report.Results[0].Status != StatusSent {
+		t.Errorf("topic results = %+v", report.Results)
+	}
+	condition := "'a' in topics && 'b' in topics"
+	report = a.SendToCondition(context.Background(), condition, &Message{Title: "title", Topic: "news"})
+	if len(report.Results) != 1 || report.Results[0].Token != condition || report.Results[0].Status != StatusSent {
+		t.Errorf("condition results = %+v", report.Results)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	topic := requests[0].Message
+	if topic.Topic != "news" || topic.Token != "" || topic.Condition != "" {
+		t.Errorf("topic request = %+v", topic)
+	}
+	// iOS devices of the topic get the category
+	aps := struct {
+		Aps struct {
+			Category string `json:"category"`
+		} `json:"aps"`
+	}{}
+	if topic.APNs == nil || json.Unmarshal(topic.APNs.Payload, &aps) != nil || aps.Aps.Category != "NEWS" {
+		t.Errorf("topic APNs = %+v", topic.APNs)
+	}
+	sent := requests[1].Message
+	if sent.Condition != condition || sent.Topic != "" || sent.Token != "" {
+		t.Errorf("condition request = %+v", sent)
+	}
+	// topic of a condition message is not a category
+	if sent.APNs != nil {
+		t.Errorf("condition APNs = %s", sent.APNs.Payload)
+	}
+}
+
+func TestAppNotification_SubscribeToTopic(t *testing.T) {
+	var (
+		mutex       Sync.Mutex
+		paths       []string
+		invalidated []string
+	)
+	a := newTopicApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("access_token_auth") != "true" {
+			t.Errorf("access_token_auth header = %q", r.Header.Get("access_token_auth"))
+		}
+		req := fcmTopicRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mutex.Lock()
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		if req.To != "/topics/news" {
+			t.Errorf("to = %q", req.To)
+		}
+		if r.URL.Path == "/iid/v1:batchRemove" {
+			_, _ = w.Write([]byte(`{"results":[{}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"results":[{},{"error":"NOT_FOUND"},{"error":"INVALID_ARGUMENT"}]}`))
+	})
+	a.SetTokenInvalidator(TokenInvalidatorFunc(func(token string, platform int, reason string) {
+		mutex.Lock()
+		invalidated = append(invalidated, token)
+		mutex.Unlock()
+	}))
+
+	report := a.SubscribeToTopic(context.Background(), FCMClientKey("shop"), "news", []string{"a", "dead", "bad"})
+	want := []DeliveryStatus{StatusSent, StatusInvalidToken, StatusFailed}
+	if len(report.Results) != len(want) {
+		t.Fatalf("subscribe results = %+v", report.Results)
+	}
+	for i, res := range report.Results {
+		if res.Status != want[i] || res.ClientKey != FCMClientKey("shop") {
+			t.Errorf("subscribe result %d = %+v, want %s", i, res, want[i])
+		}
+	}
+	if len(invalidated) != 1 || invalidated[0] != "dead" {
+		t.Errorf("invalidated = %v, want [dead]", invalidated)
+	}
+
+	report = a.UnsubscribeFromTopic(context.Background(), FCMClientKey("shop"), "/topics/news", []string{"a"})
+	if report.SuccessCount() != 1 {
+		t.Errorf("unsubscribe results = %+v", report.Results)
+	}
+	if len(paths) != 2 || paths[0] != "/iid/v1:batchAdd" || paths[1] != "/iid/v1:batchRemove" {
+		t.Errorf("paths = %v", paths)
+	}
+
+	report = a.SubscribeToTopic(context.Background(), FCMClientKey("missing"), "news", []string{"a"})
+	if len(report.Results) != 1 || report.Results[0].Err != ErrClientNotFound {
+		t.Errorf("unknown client results = %+v", report.Results)
+	}
+}
+
+func TestAppNotification_SubscribeToTopicStatus(t *testing.T) {
+	a := newTopicApp(t, func(w http.ResponseWriter, r *http.Request) {
+		req := fcmTopicRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.To {
+		case "/topics/bad name":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"InvalidTopicName"}`))
+		case "/topics/denied":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	tests := []struct {
+		topic     string
+		reason    string
+		retryable bool
+	}{
+		{"bad name", "InvalidTopicName", false},
+		{"denied", "Forbidden", false},
+		{"news", "Service Unavailable", true},
+	}
+	for _, tt := range tests {
+		report := a.SubscribeToTopic(context.Background(), FCMClientKey("shop"), tt.topic, []string{"a", "b"})
+		if len(report.Results) != 2 {
+			t.Fatalf("%s results = %+v", tt.topic, report.Results)
+		}
+		for _, res := range report.Results {
+			if res.Status != StatusFailed || res.Reason != tt.reason || res.Retryable != tt.retryable || res.Err == nil {
+				t.Errorf("%s result = %+v", tt.topic, res)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+// ConfigError list every problem found by Config.Validate
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "notification: invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate check every android, iOS, web, email and SMS config, keys and
+// templates are loaded to make sure they can be used. It return *ConfigError with all problems found.
+func (c Config) Validate() error {
+	var problems []string
+	ids := map[string]bool{}
+	for i, config := range c.fcmConfigs() {
+		name := fmt.Sprintf("android[%d]", i)
+		if config.ID != "" {
+			name = fmt.Sprintf("android[%s]", config.ID)
+		}
+		if ids[FCMClientKey(config.ID)] {
+			problems = append(problems, name+": duplicated id")
+		}
+		ids[FCMClientKey(config.ID)] = true
+		for _, problem := range config.validate() {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	for i, config := range c.apnsConfigs() {
+		name := fmt.Sprintf("ios[%d]", i)
+		if config.ID != "" {
+			name = fmt.Sprintf("ios[%s]", config.ID)
+		}
+		if ids[APNsClientKey(config.ID)] {
+			problems = append(problems, name+": duplicated id")
+		}
+		ids[APNsClientKey(config.ID)] = true
+		for _, problem := range config.validate() {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	for i, config := range c.webConfigs() {
+		name := fmt.Sprintf("web[%d]", i)
+		if config.ID != "" {
+			name = fmt.Sprintf("web[%s]", config.ID)
+		}
+		if ids[WebPushClientKey(config.ID)] {
+			problems = append(problems, name+": duplicated id")
+		}
+		ids[WebPushClientKey(config.ID)] = true
+		for _, problem := range config.validate() {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	for i, config := range c.emailConfigs() {
+		name := fmt.Sprintf("email[%d]", i)
+		if config.ID != "" {
+			name = fmt.Sprintf("email[%s]", config.ID)
+		}
+		if ids[EmailClientKey(config.ID)] {
+			problems = append(problems, name+": duplicated id")
+		}
+		ids[EmailClientKey(config.ID)] = true
+		for _, problem := range config.validate() {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	for i, config := range c.smsConfigs() {
+		name := fmt.Sprintf("sms[%d]", i)
+		if config.ID != "" {
+			name = fmt.Sprintf("sms[%s]", config.ID)
+		}
+		if ids[SMSClientKey(config.ID)] {
+			problems = append(problems, name+": duplicated id")
+		}
+		ids[SMSClientKey(config.ID)] = true
+		for _, problem := range config.validate() {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	if c.Policy != nil {
+		for _, problem := range c.Policy.validate() {
+			problems = append(problems, "policy: "+problem)
+		}
+	}
+	if c.Retry != nil && (c.Retry.Jitter < 0 || c.Retry.Jitter > 1) {
+		problems = append(problems, "retry: jitter must be between 0 and 1")
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *FCMConfig) validate() []string {
+	if !c.UseV1() {
+		if c.ServerKey == "" {
+			return []string{"server_key or service_account_file/service_account_data is required"}
+		}
+		return nil
+	}
+
+	data := []byte(c.ServiceAccountData)
+	if c.ServiceAccountFile != "" {
+		var err error
+		data, err = ioutil.ReadFile(c.ServiceAccountFile)
+		if err != nil {
+			return []string{"can not read service_account_file: " + err.Error()}
+		}
+	}
+	var problems []string
+	if _, err := google.JWTConfigFromJSON(data, fcmV1Scope); err != nil {
+		problems = append(problems, "invalid service account: "+err.Error())
+	}
+	if c.ProjectID == "" {
+		account := struct {
+			ProjectID string `json:"project_id"`
+		}{}
+		_ = json.Unmarshal(data, &account)
+		if account.ProjectID == "" {
+			problems = append(problems, "project_id is required")
+		}
+	}
+	return problems
+}
+
+func (c *APNsConfig) validate() []string {
+	var problems []string
+	if c.AppBundleID == "" {
+		problems = append(problems, "app_bundle_id is required")
+	}
+	if c.keySources() > 1 {
+		return append(problems, "only one of key_path, key bytes, key_base64, key_data can be set")
+	}
+	switch c.keyType() {
+	case "p12", "pem":
+		if !c.hasKey() {
+			problems = append(problems, "key_path, key_base64 or key_data is required for ."+c.keyType())
+			break
+		}
+		if _, err := apnsCertificate(c); err != nil {
+			problems = append(problems, fmt.Sprintf("can not read .%s key (check key and password): %s", c.keyType(), err.Error()))
+		}
+	case "p8":
+		if c.KeyID == "" {
+			problems = append(problems, "p8_key_id is required for .p8")
+		}
+		if c.TeamID == "" {
+			problems = append(problems, "p8_team_id is required for .p8")
+		}
+		if !c.hasKey() {
+			problems = append(problems, "key_path, key_base64 or key_data is required for .p8")
+			break
+		}
+		if _, err := apnsAuthKey(c); err != nil {
+			problems = append(problems, "can not read .p8 key: "+err.Error())
+		}
+	case "":
+		problems = append(problems, "key_type is required")
+	default:
+		problems = append(problems, fmt.Sprintf("key_type %q is invalid, only support p12, pem, p8", c.keyType()))
+	}
+	return problems
+}
+
+func (c *WebPushConfig) validate() []string {
+	var problems []string
+	if c.Subject == "" {
+		problems = append(problems, "subject is required")
+	} else if !strings.HasPrefix(c.Subject, "mailto:") && !strings.HasPrefix(c.Subject, "https://") {
+		problems = append(problems, "subject must be a mailto: or https:// url")
+	}
+	if _, err := vapidPrivateKey(c.VAPIDPublicKey, c.VAPIDPrivateKey); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+func (c *EmailConfig) validate() []string {
+	if _, err := EmailClientFromConfig(c); err != nil {
+		return []string{strings.TrimPrefix(err.Error(), "platform Email: ")}
+	}
+	return nil
+}
+
+func (c *SMSConfig) validate() []string {
+	if _, err := SMSClientFromConfig(c); err != nil {
+		return []string{strings.TrimPrefix(err.Error(), "platform SMS: ")}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package notification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	config := Config{
+		AndroidConfig: &FCMConfig{ID: "app"},
+		IOSConfig: &APNsConfig{
+			ID:          "app",
+			KeyType:     "p8",
+			KeyFilePath: "./not-found.p8",
+		},
+		IOSConfigs: []*APNsConfig{
+			{ID: "app", KeyType: "p13", AppBundleID: "com.example.app"},
+		},
+	}
+	err := config.Validate()
+	configErr := &ConfigError{}
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Validate() error = %v, want ConfigError", err)
+	}
+	want := []string{
+		"android[app]: server_key or service_account_file/service_account_data is required",
+		"ios[app]: app_bundle_id is required",
+		"ios[app]: p8_key_id is required for .p8",
+		"ios[app]: p8_team_id is required for .p8",
+		"ios[app]: can not read .p8 key",
+		"ios[app]: duplicated id",
+		`ios[app]: key_type "p13" is invalid`,
+	}
+	if len(configErr.Problems) != len(want) {
+		t.Fatalf("Validate() problems = %v, want %d problems", configErr.Problems, len(want))
+	}
+	for i, problem := range configErr.Problems {
+		if !strings.HasPrefix(problem, want[i]) {
+			t.Errorf("Problems[%d] = %q, want prefix %q", i, problem, want[i])
+		}
+	}
+
+	if _, err := NewAppNotification(config); err == nil {
+		t.Error("NewAppNotification() error = nil, want error")
+	}
+	if err := (Config{}).Validate(); err != nil {
+		t.Errorf("Validate() of empty config error = %v", err)
+	}
+}
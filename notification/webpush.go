@@ -0,0 +1,394 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	Sync "sync"
+	"time"
+)
+
+//=================================================================
+// Browser service - Web Push
+// - RFC 8030 push, RFC 8291 aes128gcm encryption, RFC 8292 VAPID
+// - Token is the json of browser PushSubscription
+//=================================================================
+const (
+	// max size of encrypted record
+	WebPushMaxPayloadSize = 4096
+	// salt(16) + record size(4) + key id length(1) + key id(65)
+	webPushHeaderSize = 86
+	// AES-GCM tag(16) + padding delimiter(1)
+	webPushOverhead = 17
+
+	defaultWebPushTTL         = 24 * time.Hour
+	defaultWebPushConcurrency = 10
+	vapidTokenLifetime        = 12 * time.Hour
+)
+
+type WebPushConfig struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	// VAPID keys, base64url (no padding) of uncompressed P-256 public key and
+	// 32 bytes private key, as generated by most web push libraries
+	VAPIDPublicKey  string `json:"vapid_public_key"`
+	VAPIDPrivateKey string `json:"vapid_private_key"`
+	// contact of sender, "mailto:admin@example.com" or https url
+	Subject string `json:"subject"`
+	// number of concurrent pushes, default 10
+	Concurrency int `json:"concurrency"`
+}
+
+// WebPushSubscription is PushSubscription.toJSON() of browser
+type WebPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Token encode subscription to be used in Message.Tokens
+func (s WebPushSubscription) Token() string {
+	raw, _ := json.Marshal(s)
+	return string(raw)
+}
+
+// WebPushOptions override web push headers and notification fields
+type WebPushOptions struct {
+	// how long push service keep the message, default 24h
+	TTL time.Duration
+	// very-low, low, normal or high
+	Urgency string
+	// replace pending message with the same topic
+	Topic string
+	Icon  string
+	Image string
+	// small monochrome image shown in the status bar, Message.Badge is an
+	// APNs count so it is not used on the web
+	BadgeURL string
+	// page opened when user click notification, handled by service worker
+	URL string
+}
+
+// webOptions return web push options of msg, never nil
+func (m *Message) webOptions() *WebPushOptions {
+	if m.Web != nil {
+		return m.Web
+	}
+	return &WebPushOptions{}
+}
+
+// webPushPayload is json sent to service worker
+type webPushPayload struct {
+	Title string                 `json:"title,omitempty"`
+	Body  string                 `json:"body,omitempty"`
+	Icon  string                 `json:"icon,omitempty"`
+	Image string                 `json:"image,omitempty"`
+	URL   string                 `json:"url,omitempty"`
+	Badge string                 `json:"badge,omitempty"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+type WebPushClient struct {
+	Subject     string
+	Concurrency int
+
+	privateKey *ecdsa.PrivateKey
+	publicKey  string
+	httpClient *http.Client
+
+	// VAPID JWT cached per push service origin
+	mutex  Sync.Mutex
+	tokens map[string]vapidToken
+}
+
+type vapidToken struct {
+	value   string
+	expires time.Time
+}
+
+// WebPushClientFromConfig create web push client from VAPID keys of config
+func WebPushClientFromConfig(config *WebPushConfig) (*WebPushClient, error) {
+	privateKey, err := vapidPrivateKey(config.VAPIDPublicKey, config.VAPIDPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if config.Subject == "" {
+		return nil, errors.New("platform WebPush: subject is required")
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultWebPushConcurrency
+	}
+	return &WebPushClient{
+		Subject:     config.Subject,
+		Concurrency: concurrency,
+		privateKey:  privateKey,
+		publicKey:   strings.TrimRight(config.VAPIDPublicKey, "="),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		tokens:      map[string]vapidToken{},
+	}, nil
+}
+
+// vapidPrivateKey decode VAPID key pair and check they match
+func vapidPrivateKey(publicKey string, privateKey string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64URL(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("platform WebPush: vapid_private_key must be base64url of 32 bytes")
+	}
+	pub, err := decodeBase64URL(publicKey)
+	if err != nil || len(pub) != 65 {
+		return nil, errors.New("platform WebPush: vapid_public_key must be base64url of 65 bytes uncompressed point")
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("platform WebPush: invalid vapid_private_key: %w", err)
+	}
+	if !bytes.Equal(key.PublicKey().Bytes(), pub) {
+		return nil, errors.New("platform WebPush: vapid_public_key does not match vapid_private_key")
+	}
+	x, y := new(big.Int).SetBytes(pub[1:33]), new(big.Int).SetBytes(pub[33:])
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(d),
+	}, nil
+}
+
+func (c *WebPushClient) Send(ctx context.Context, msg *Message) (*DeliveryReport, error) {
+	opts := msg.webOptions()
+	msgData, err := encodePayloadData(PLATFORM_WebPush, msg.PayloadData)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(webPushPayload{
+		Title: msg.Title,
+		Body:  msg.Body,
+		Icon:  opts.Icon,
+		Image: opts.Image,
+		URL:   opts.URL,
+		Badge: opts.BadgeURL,
+		Data:  msgData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPayloadSize(PLATFORM_WebPush, plaintext, WebPushMaxPayloadSize-webPushHeaderSize-webPushOverhead); err != nil {
+		return nil, err
+	}
+
+	results := sendEach(msg.Tokens, c.Concurrency, func(token string) TokenResult {
+		return c.sendOne(ctx, token, plaintext, opts)
+	})
+	return &DeliveryReport{Results: results}, nil
+}
+
+func (c *WebPushClient) sendOne(ctx context.Context, token string, plaintext []byte, opts *WebPushOptions) TokenResult {
+	result := TokenResult{
+		Token:    token,
+		Platform: PLATFORM_WebPush,
+	}
+	subscription := WebPushSubscription{}
+	// FCM/APNs tokens are sent here too by SendMessageForAll, a token which
+	// is not a subscription is not for this client so it is skipped
+	if err := json.Unmarshal([]byte(token), &subscription); err != nil || subscription.Endpoint == "" {
+		result.Status = StatusSkipped
+		result.Reason = "InvalidSubscription"
+		return result
+	}
+	body, err := encryptWebPush(subscription, plaintext)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Reason = "InvalidSubscriptionKeys"
+		result.Err = err
+		return result
+	}
+	authorization, err := c.vapidAuthorization(subscription.Endpoint)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Reason = "InvalidEndpoint"
+		result.Err = err
+		return result
+	}
+
+	req, err := http.NewRequest(http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		result.Status = StatusFailed
+		result.Reason = "InvalidEndpoint"
+		result.Err = err
+		return result
+	}
+	req = req.WithContext(ctx)
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = defaultWebPushTTL
+	}
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", authorization)
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Retryable = ctx.Err() == nil
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	result.MessageID = resp.Header.Get("Location")
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		result.Status = StatusSent
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// subscription expired or unsubscribed
+		result.Status = StatusInvalidToken
+		result.Reason = http.StatusText(resp.StatusCode)
+	default:
+		result.Status = StatusFailed
+		result.Reason = http.StatusText(resp.StatusCode)
+		result.Retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		result.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return result
+}
+
+// vapidAuthorization return Authorization header for endpoint (RFC 8292),
+// JWT is signed once per origin and reused until it is about to expire
+func (c *WebPushClient) vapidAuthorization(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return "", fmt.Errorf("platform WebPush: invalid endpoint %q", endpoint)
+	}
+	audience := endpointURL.Scheme + "://" + endpointURL.Host
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, found := c.tokens[audience]
+	if !found || time.Until(cached.expires) < time.Hour {
+		expires := time.Now().Add(vapidTokenLifetime)
+		value, err := c.signVAPID(audience, expires)
+		if err != nil {
+			return "", err
+		}
+		cached = vapidToken{value: value, expires: expires}
+		c.tokens[audience] = cached
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", cached.value, c.publicKey), nil
+}
+
+// signVAPID create ES256 JWT
+func (c *WebPushClient) signVAPID(audience string, expires time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": expires.Unix(),
+		"sub": c.Subject,
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, c.privateKey, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS use fixed size r || s
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// encryptWebPush encrypt plaintext for subscription with aes128gcm (RFC 8291)
+func encryptWebPush(subscription WebPushSubscription, plaintext []byte) ([]byte, error) {
+	uaPublicRaw, err := decodeBase64URL(subscription.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	authSecret, err := decodeBase64URL(subscription.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("decode auth: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %w", err)
+	}
+
+	// ephemeral application server key
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublicRaw := asPrivate.PublicKey().Bytes()
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// single record, 0x02 mark the last record
+	record := append(append([]byte{}, plaintext...), 0x02)
+
+	header := make([]byte, 0, webPushHeaderSize)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, WebPushMaxPayloadSize)
+	header = append(header, byte(len(asPublicRaw)))
+	header = append(header, asPublicRaw...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// hkdf is HKDF-SHA-256 (RFC 5869) for output up to 32 bytes
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
+
+// decodeBase64URL accept base64url with or without padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
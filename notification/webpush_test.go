@@ -0,0 +1,119 @@
+package notification
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decryptWebPush is what browser does with aes128gcm body (RFC 8291)
+func decryptWebPush(t *testing.T, uaPrivate *ecdh.PrivateKey, authSecret []byte, body []byte) []byte {
+	salt := body[:16]
+	recordSize := binary.BigEndian.Uint32(body[16:20])
+	keyIDLen := int(body[20])
+	asPublicRaw := body[21 : 21+keyIDLen]
+	if recordSize != WebPushMaxPayloadSize {
+		t.Errorf("record size = %d", recordSize)
+	}
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdhSecret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+keyIDLen:], nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if plaintext[len(plaintext)-1] != 0x02 {
+		t.Errorf("missing last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestWebPushClient_Send(t *testing.T) {
+	vapidKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := make([]byte, 16)
+	_, _ = rand.Read(authSecret)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if got := r.Header.Get("Content-Encoding"); got != "aes128gcm" {
+			t.Errorf("Content-Encoding = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); !strings.HasPrefix(got, "vapid t=") ||
+			!strings.HasSuffix(got, ", k="+base64.RawURLEncoding.EncodeToString(vapidKey.PublicKey().Bytes())) {
+			t.Errorf("Authorization = %q", got)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := webPushPayload{}
+		if err := json.Unmarshal(decryptWebPush(t, uaKey, authSecret, body), &payload); err != nil {
+			t.Fatal(err)
+		}
+		// APNs badge count is not a badge image
+		if payload.Title != "title" || payload.Data["order_id"] != "1" || payload.Badge != "/badge.png" {
+			t.Errorf("payload = %+v", payload)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client, err := WebPushClientFromConfig(&WebPushConfig{
+		VAPIDPublicKey:  base64.RawURLEncoding.EncodeToString(vapidKey.PublicKey().Bytes()),
+		VAPIDPrivateKey: base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()),
+		Subject:         "mailto:admin@example.com",
+	})
+	if err != nil {
+		t.Fatalf("WebPushClientFromConfig() error = %v", err)
+	}
+	subscription := func(path string) string {
+		s := WebPushSubscription{Endpoint: server.URL + path}
+		s.Keys.P256dh = base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes())
+		s.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+		return s.Token()
+	}
+	report, err := client.Send(context.Background(), &Message{
+		Title:       "title",
+		Badge:       "3",
+		Web:         &WebPushOptions{BadgeURL: "/badge.png"},
+		PayloadData: map[string]interface{}{"order_id": "1"},
+		Tokens:      []string{subscription("/push"), subscription("/gone"), "not a subscription"},
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	// FCM/APNs token sent to web clients is not ours, it is neither
+	// invalidated nor a failure
+	want := []DeliveryStatus{StatusSent, StatusInvalidToken, StatusSkipped}
+	for i, res := range report.Results {
+		if res.Status != want[i] {
+			t.Errorf("Results[%d].Status = %v, want %v", i, res.Status, want[i])
+		}
+	}
+	if report.FailureCount() != 1 || report.SkippedCount() != 1 {
+		t.Errorf("FailureCount() = %d, SkippedCount() = %d", report.FailureCount(), report.SkippedCount())
+	}
+}